@@ -0,0 +1,322 @@
+// Package archive reads the files in archives: zip files, including jars
+// and wheels, and tar files, uncompressed or compressed with gzip or
+// bzip2. (The standard library has no xz or zstd decompressor.) It also
+// decompresses single compressed files, like "app.log.gz".
+//
+// A file in an archive is named by the name of the archive, Sep and its
+// path in the archive, like "src.tar.gz!/src/main.go", as in jar URLs.
+// Archives may be nested: "dist.tar.gz!/lib.whl!/pkg/mod.py" is the file
+// pkg/mod.py in the wheel lib.whl in dist.tar.gz.
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+)
+
+// Sep separates the name of an archive from the path of a file in it.
+const Sep = "!/"
+
+// LegacySep separated the name of a zip file from the path of a file in it
+// in indexes made by cindex -zip or by csindex before Sep, like
+// "src.zip\x01main.go".
+const LegacySep = "\x01"
+
+// FromLegacy returns name, which may be named with LegacySep, named with
+// Sep instead.
+func FromLegacy(name string) string {
+	if !strings.Contains(name, LegacySep) {
+		return name
+	}
+	var b strings.Builder
+	for {
+		i := strings.Index(name, LegacySep)
+		if i < 0 {
+			b.WriteString(name)
+			return b.String()
+		}
+		b.WriteString(name[:i])
+		if kindOf(name[:i]) == zipKind {
+			b.WriteString(Sep)
+		} else {
+			b.WriteString(LegacySep)
+		}
+		name = name[i+len(LegacySep):]
+	}
+}
+
+type kind int
+
+const (
+	none kind = iota
+	zipKind
+	tarKind
+	tarGzipKind
+	tarBzip2Kind
+)
+
+var suffixes = []struct {
+	suffix string
+	kind   kind
+}{
+	{".zip", zipKind},
+	{".jar", zipKind},
+	{".war", zipKind},
+	{".ear", zipKind},
+	{".whl", zipKind},
+	{".egg", zipKind},
+	{".tar", tarKind},
+	{".tar.gz", tarGzipKind},
+	{".tgz", tarGzipKind},
+	{".tar.bz2", tarBzip2Kind},
+	{".tbz2", tarBzip2Kind},
+	{".tbz", tarBzip2Kind},
+}
+
+func kindOf(name string) kind {
+	name = strings.ToLower(name)
+	for _, s := range suffixes {
+		if strings.HasSuffix(name, s.suffix) {
+			return s.kind
+		}
+	}
+	return none
+}
+
+// Is reports whether the named file is an archive, judging by its name.
+func Is(name string) bool {
+	return kindOf(name) != none
+}
+
+// seps returns the indexes of the separators in name that follow the name
+// of an archive.
+func seps(name string) []int {
+	var is []int
+	for i := 0; ; {
+		j := strings.Index(name[i:], Sep)
+		if j < 0 {
+			return is
+		}
+		i += j
+		if Is(name[:i]) {
+			is = append(is, i)
+		}
+		i += len(Sep)
+	}
+}
+
+// Split splits the name of a file in an archive into the name of the
+// innermost archive containing it and its path in that archive. It reports
+// false if name is not in an archive.
+func Split(name string) (archive, file string, ok bool) {
+	is := seps(name)
+	if len(is) == 0 {
+		return "", "", false
+	}
+	i := is[len(is)-1]
+	return name[:i], name[i+len(Sep):], true
+}
+
+// Outer returns the name of the file on disk containing the named file:
+// the outermost archive, or name itself if it is not in an archive.
+func Outer(name string) string {
+	if is := seps(name); len(is) > 0 {
+		return name[:is[0]]
+	}
+	return name
+}
+
+// Parts splits name into the name of the file on disk and the paths in the
+// archives nested in it, like Outer and Split.
+func Parts(name string) []string {
+	var parts []string
+	i := 0
+	for _, j := range seps(name) {
+		parts = append(parts, name[i:j])
+		i = j + len(Sep)
+	}
+	return append(parts, name[i:])
+}
+
+// maxSize is the maximum size of the files of a tar archive or of a
+// nested archive, which are read into memory.
+const maxSize = 1 << 30
+
+// FS is an open archive. Its files are named by their paths in the
+// archive, with directories synthesized as needed.
+type FS struct {
+	fs.FS
+	Name  string // name of the archive
+	close func() error
+}
+
+// Close closes the archive.
+func (a *FS) Close() error {
+	if a.close == nil {
+		return nil
+	}
+	return a.close()
+}
+
+// Open opens the named archive, which may be nested in other archives.
+func Open(name string) (*FS, error) {
+	parts := Parts(name)
+	outer, nested := parts[0], parts[1:]
+	if kindOf(outer) == zipKind {
+		zr, err := zip.OpenReader(outer)
+		if err != nil {
+			return nil, err
+		}
+		a := &FS{FS: zr, Name: outer, close: zr.Close}
+		if len(nested) == 0 {
+			return a, nil
+		}
+		defer a.Close()
+		return a.openNested(nested)
+	}
+	f, err := os.Open(outer)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	a, err := read(outer, f)
+	if err != nil {
+		return nil, err
+	}
+	if len(nested) == 0 {
+		return a, nil
+	}
+	return a.openNested(nested)
+}
+
+// openNested opens the archive nested in a at the given paths: the path of
+// an archive in a, the path of an archive in that, and so on.
+func (a *FS) openNested(paths []string) (*FS, error) {
+	for _, p := range paths {
+		b, err := a.Sub(p)
+		if err != nil {
+			return nil, err
+		}
+		a = b
+	}
+	return a, nil
+}
+
+// Sub opens the archive at the path file in a. It is read into memory.
+func (a *FS) Sub(file string) (*FS, error) {
+	name := a.Name + Sep + file
+	if !Is(file) {
+		return nil, fmt.Errorf("%s: not an archive", name)
+	}
+	f, err := a.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, maxSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	if len(data) > maxSize {
+		return nil, fmt.Errorf("%s: archive too large", name)
+	}
+	if kindOf(file) == zipKind {
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		return &FS{FS: zr, Name: name}, nil
+	}
+	return read(name, bytes.NewReader(data))
+}
+
+// read reads the named tar archive from r.
+func read(name string, r io.Reader) (*FS, error) {
+	var err error
+	switch kindOf(name) {
+	case tarGzipKind:
+		r, err = gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+	case tarBzip2Kind:
+		r = bzip2.NewReader(r)
+	case tarKind:
+	default:
+		return nil, fmt.Errorf("%s: not an archive", name)
+	}
+	fsys, err := readTar(r)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return &FS{FS: fsys, Name: name}, nil
+}
+
+// readTar reads the regular files and directories of the tar archive r
+// into memory. Later entries replace earlier ones of the same name.
+func readTar(r io.Reader) (*memFS, error) {
+	fsys := newMemFS()
+	tr := tar.NewReader(r)
+	total := int64(0)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return fsys, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := path.Clean(strings.TrimPrefix(h.Name, "/"))
+		if !fs.ValidPath(name) || name == "." {
+			continue
+		}
+		switch h.Typeflag {
+		case tar.TypeDir:
+			fsys.add(name, nil, h.ModTime, true)
+		case tar.TypeReg:
+			total += h.Size
+			if total > maxSize {
+				return nil, errors.New("archive too large")
+			}
+			data, err := io.ReadAll(tr)
+			if err != nil {
+				return nil, err
+			}
+			fsys.add(name, data, h.ModTime, false)
+		}
+	}
+}
+
+// Walk calls fn for each regular file in the archive a and in the archives
+// nested in it, with the name of the file and the archive and path to open
+// it from. Nested archives are not reported themselves. Walk stops if fn
+// returns an error and returns it; errors opening nested archives are
+// passed to fn with a nil archive.
+func Walk(a *FS, fn func(name string, arch *FS, file string, err error) error) error {
+	return fs.WalkDir(a, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return fn(a.Name+Sep+p, nil, p, err)
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		if !Is(p) {
+			return fn(a.Name+Sep+p, a, p, nil)
+		}
+		b, err := a.Sub(p)
+		if err != nil {
+			return fn(a.Name+Sep+p, nil, p, err)
+		}
+		return Walk(b, fn)
+	})
+}
@@ -0,0 +1,198 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		name          string
+		archive, file string
+		ok            bool
+		outer         string
+		parts         []string
+	}{
+		{"src/main.go", "", "", false, "src/main.go", []string{"src/main.go"}},
+		{"src.zip!/main.go", "src.zip", "main.go", true, "src.zip", []string{"src.zip", "main.go"}},
+		{"app.JAR!/META-INF/MANIFEST.MF", "app.JAR", "META-INF/MANIFEST.MF", true, "app.JAR", []string{"app.JAR", "META-INF/MANIFEST.MF"}},
+		{"dist.tar.gz!/lib.whl!/pkg/mod.py", "dist.tar.gz!/lib.whl", "pkg/mod.py", true, "dist.tar.gz", []string{"dist.tar.gz", "lib.whl", "pkg/mod.py"}},
+		{"dir!/x.zip!/a.go", "dir!/x.zip", "a.go", true, "dir!/x.zip", []string{"dir!/x.zip", "a.go"}},
+		{"notes!/todo.txt", "", "", false, "notes!/todo.txt", []string{"notes!/todo.txt"}},
+		{"src.zip\x01main.go", "", "", false, "src.zip\x01main.go", []string{"src.zip\x01main.go"}},
+	}
+	for _, tt := range tests {
+		archive, file, ok := Split(tt.name)
+		if archive != tt.archive || file != tt.file || ok != tt.ok {
+			t.Errorf("Split(%q) = %q, %q, %v, want %q, %q, %v", tt.name, archive, file, ok, tt.archive, tt.file, tt.ok)
+		}
+		if outer := Outer(tt.name); outer != tt.outer {
+			t.Errorf("Outer(%q) = %q, want %q", tt.name, outer, tt.outer)
+		}
+		if parts := Parts(tt.name); !reflect.DeepEqual(parts, tt.parts) {
+			t.Errorf("Parts(%q) = %q, want %q", tt.name, parts, tt.parts)
+		}
+	}
+}
+
+func TestFromLegacy(t *testing.T) {
+	tests := []struct {
+		name, want string
+	}{
+		{"src/main.go", "src/main.go"},
+		{"src.zip!/main.go", "src.zip!/main.go"},
+		{"src.zip\x01main.go", "src.zip!/main.go"},
+		{"/data/App.Jar\x01com/x/A.class", "/data/App.Jar!/com/x/A.class"},
+		{"lib.whl\x01pkg/inner.zip\x01a.py", "lib.whl!/pkg/inner.zip!/a.py"},
+		{"src.tar\x01main.go", "src.tar\x01main.go"},
+		{"odd\x01name.zip\x01a", "odd\x01name.zip!/a"},
+		{"trailing.zip\x01", "trailing.zip!/"},
+	}
+	for _, tt := range tests {
+		if got := FromLegacy(tt.name); got != tt.want {
+			t.Errorf("FromLegacy(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// tarFile is a file of a tar archive made by makeTar.
+type tarFile struct {
+	name string
+	data string
+	typ  byte // tar.TypeReg if 0
+}
+
+func makeTar(t *testing.T, files []tarFile) []byte {
+	var b bytes.Buffer
+	tw := tar.NewWriter(&b)
+	for _, f := range files {
+		h := &tar.Header{Name: f.name, Typeflag: f.typ, Size: int64(len(f.data)), Mode: 0o644, ModTime: time.Unix(1e9, 0)}
+		if h.Typeflag == 0 {
+			h.Typeflag = tar.TypeReg
+		}
+		if h.Typeflag != tar.TypeReg {
+			h.Size = 0
+		}
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+		if h.Size > 0 {
+			if _, err := io.WriteString(tw, f.data); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func makeZip(t *testing.T, files map[string]string) []byte {
+	var b bytes.Buffer
+	zw := zip.NewWriter(&b)
+	for name, data := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, data)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func gzipped(t *testing.T, data []byte) []byte {
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	zw.Write(data)
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+// TestNested reads a tar.gz archive with a wheel and a tar archive in it.
+func TestNested(t *testing.T) {
+	whl := makeZip(t, map[string]string{"pkg/mod.py": "import os\n", "pkg/__init__.py": ""})
+	inner := makeTar(t, []tarFile{{name: "notes.txt", data: "inner\n"}})
+	dist := gzipped(t, makeTar(t, []tarFile{
+		{name: "dist/", typ: tar.TypeDir},
+		{name: "dist/README", data: "old\n"},
+		{name: "dist/README", data: "new\n"}, // replaces the first
+		{name: "/abs/path.go", data: "package abs\n"},
+		{name: "../escape.go", data: "package escape\n"},
+		{name: "dist/link", typ: tar.TypeSymlink},
+		{name: "dist/lib.whl", data: string(whl)},
+		{name: "deep/dir/inner.tar", data: string(inner)},
+	}))
+	dir := t.TempDir()
+	name := filepath.Join(dir, "dist.tar.gz")
+	if err := os.WriteFile(name, dist, 0o666); err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	if err := fstest.TestFS(a, "dist/README", "abs/path.go", "dist/lib.whl", "deep/dir/inner.tar"); err != nil {
+		t.Error(err)
+	}
+	if _, err := fs.Stat(a, "escape.go"); err == nil {
+		t.Error("entry outside the archive was read")
+	}
+
+	files := make(map[string]string)
+	err = Walk(a, func(name string, arch *FS, file string, err error) error {
+		if err != nil {
+			return err
+		}
+		data, err := fs.ReadFile(arch, file)
+		files[name] = string(data)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		name + "!/dist/README":                   "new\n",
+		name + "!/abs/path.go":                   "package abs\n",
+		name + "!/dist/lib.whl!/pkg/mod.py":      "import os\n",
+		name + "!/dist/lib.whl!/pkg/__init__.py": "",
+		name + "!/deep/dir/inner.tar!/notes.txt": "inner\n",
+	}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("Walk found %q, want %q", files, want)
+	}
+
+	for file, data := range want {
+		arch, p, _ := Split(file)
+		b, err := Open(arch)
+		if err != nil {
+			t.Errorf("Open(%q): %v", arch, err)
+			continue
+		}
+		got, err := fs.ReadFile(b, p)
+		b.Close()
+		if err != nil || string(got) != data {
+			t.Errorf("%s: read %q, %v, want %q", file, got, err, data)
+		}
+	}
+	if _, err := Open(name + Sep + "dist/README"); err == nil {
+		t.Error("opened a file that is not an archive as one")
+	}
+}
@@ -0,0 +1,57 @@
+package archive
+
+import (
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// compressions are the suffixes of the names of compressed files.
+var compressions = []string{".gz", ".bz2"}
+
+// compression returns the suffix of the compression of the named file, or ""
+// if it is not compressed or is a compressed tar archive.
+func compression(name string) string {
+	if Is(name) {
+		return ""
+	}
+	lower := strings.ToLower(name)
+	for _, s := range compressions {
+		if strings.HasSuffix(lower, s) {
+			return s
+		}
+	}
+	return ""
+}
+
+// IsCompressed reports whether the named file is a single file compressed
+// with gzip or bzip2, like "app.log.gz", judging by its name. Compressed tar
+// archives are archives, not compressed files.
+func IsCompressed(name string) bool {
+	return compression(name) != ""
+}
+
+// Decompressed returns the name of the contents of the named compressed
+// file: name without the suffix of its compression. It returns other names
+// unchanged.
+func Decompressed(name string) string {
+	return name[:len(name)-len(compression(name))]
+}
+
+// Decompress returns a reader of the decompressed contents of the named
+// compressed file read from r.
+func Decompress(name string, r io.Reader) (io.Reader, error) {
+	switch compression(name) {
+	case ".gz":
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		return zr, nil
+	case ".bz2":
+		return bzip2.NewReader(r), nil
+	}
+	return nil, fmt.Errorf("%s: not compressed", name)
+}
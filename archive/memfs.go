@@ -0,0 +1,128 @@
+package archive
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// memFS is a file system in memory.
+type memFS struct {
+	files map[string]*memFile // by path; "." is the root
+}
+
+type memFile struct {
+	name    string // base name
+	data    []byte
+	modTime time.Time
+	dir     bool
+	entries []string // base names of the files in a directory
+}
+
+func newMemFS() *memFS {
+	return &memFS{files: map[string]*memFile{".": {name: ".", dir: true}}}
+}
+
+// add adds the file or directory p, creating its parent directories.
+func (m *memFS) add(p string, data []byte, modTime time.Time, dir bool) {
+	if f := m.files[p]; f != nil {
+		if f.dir == dir {
+			f.data, f.modTime = data, modTime
+		}
+		return
+	}
+	m.files[p] = &memFile{name: path.Base(p), data: data, modTime: modTime, dir: dir}
+	parent := path.Dir(p)
+	if m.files[parent] == nil {
+		m.add(parent, nil, modTime, true)
+	}
+	if d := m.files[parent]; d.dir {
+		d.entries = append(d.entries, path.Base(p))
+	}
+}
+
+func (m *memFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	f := m.files[name]
+	if f == nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if f.dir {
+		return &memDir{f: f, fsys: m, path: name}, nil
+	}
+	return &openMemFile{f: f, Reader: bytes.NewReader(f.data)}, nil
+}
+
+// ReadDir implements fs.ReadDirFS.
+func (m *memFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	f, err := m.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	d, ok := f.(*memDir)
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	return d.ReadDir(-1)
+}
+
+func (f *memFile) Name() string { return f.name }
+func (f *memFile) Size() int64  { return int64(len(f.data)) }
+func (f *memFile) Mode() fs.FileMode {
+	if f.dir {
+		return fs.ModeDir | 0o555
+	}
+	return 0o444
+}
+func (f *memFile) ModTime() time.Time         { return f.modTime }
+func (f *memFile) IsDir() bool                { return f.dir }
+func (f *memFile) Sys() any                   { return nil }
+func (f *memFile) Type() fs.FileMode          { return f.Mode().Type() }
+func (f *memFile) Info() (fs.FileInfo, error) { return f, nil }
+
+type openMemFile struct {
+	f *memFile
+	*bytes.Reader
+}
+
+func (o *openMemFile) Stat() (fs.FileInfo, error) { return o.f, nil }
+func (o *openMemFile) Close() error               { return nil }
+
+type memDir struct {
+	f    *memFile
+	fsys *memFS
+	path string
+	read int // number of entries read by ReadDir
+}
+
+func (d *memDir) Stat() (fs.FileInfo, error) { return d.f, nil }
+func (d *memDir) Close() error               { return nil }
+
+func (d *memDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.path, Err: fs.ErrInvalid}
+}
+
+// ReadDir returns the entries in name order.
+func (d *memDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	names := slices.Clone(d.f.entries)
+	slices.SortFunc(names, strings.Compare)
+	names = names[d.read:]
+	if n > 0 && len(names) > n {
+		names = names[:n]
+	}
+	if n > 0 && len(names) == 0 {
+		return nil, io.EOF
+	}
+	d.read += len(names)
+	entries := make([]fs.DirEntry, len(names))
+	for i, name := range names {
+		entries[i] = d.fsys.files[path.Join(d.path, name)]
+	}
+	return entries, nil
+}
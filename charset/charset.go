@@ -0,0 +1,256 @@
+// Package charset detects the encodings of text files and decodes them to
+// UTF-8: UTF-8, with or without a byte order mark, UTF-16 and Windows-1252,
+// the superset of Latin-1 that legacy files are usually in.
+package charset
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// An Encoding is a text encoding.
+type Encoding struct {
+	Name string
+	bom  []byte
+	// decode appends the text of src to dst, decoded to UTF-8. It returns
+	// the number of bytes of src decoded, leaving an incomplete character
+	// at the end of src unless atEOF.
+	decode func(dst, src []byte, atEOF bool) ([]byte, int)
+}
+
+var (
+	UTF8        = &Encoding{Name: "UTF-8", decode: decodeUTF8}
+	UTF8BOM     = &Encoding{Name: "UTF-8 with BOM", bom: []byte{0xef, 0xbb, 0xbf}, decode: decodeUTF8}
+	UTF16LE     = &Encoding{Name: "UTF-16LE", bom: []byte{0xff, 0xfe}, decode: decodeUTF16LE}
+	UTF16BE     = &Encoding{Name: "UTF-16BE", bom: []byte{0xfe, 0xff}, decode: decodeUTF16BE}
+	Windows1252 = &Encoding{Name: "Windows-1252", decode: decodeWindows1252}
+)
+
+// SniffLen is the length of the prefix of data that Detect examines. Data
+// longer than it continues past the prefix, which may end in the middle of
+// a character.
+const SniffLen = 64 << 10
+
+// textLen is the length of the prefix of the text that must not contain
+// control characters, like the isText check of csweb it replaces.
+const textLen = 1024
+
+// Detect returns the encoding of the text data, judging by its byte order
+// mark or else by which encoding decodes its prefix to text, or nil if data
+// is not text.
+func Detect(data []byte) *Encoding {
+	atEOF := len(data) <= SniffLen
+	if !atEOF {
+		data = data[:SniffLen]
+	}
+	for _, e := range []*Encoding{UTF8BOM, UTF16LE, UTF16BE} {
+		if bytes.HasPrefix(data, e.bom) {
+			if !e.isText(data[len(e.bom):], atEOF) {
+				return nil
+			}
+			return e
+		}
+	}
+	if UTF8.isText(data, atEOF) {
+		return UTF8
+	}
+	if bytes.IndexByte(data, 0) >= 0 {
+		// text in UTF-16 has zero bytes in every other byte: the high
+		// bytes of ASCII characters
+		if e := guessUTF16(data); e != nil && e.isText(data, atEOF) {
+			return e
+		}
+		return nil
+	}
+	if Windows1252.isText(data, atEOF) {
+		return Windows1252
+	}
+	return nil
+}
+
+// guessUTF16 returns the byte order of data if it looks like UTF-16 text
+// without a byte order mark, or nil.
+func guessUTF16(data []byte) *Encoding {
+	var even, odd int // zero bytes at even and odd offsets
+	for i, b := range data {
+		if b == 0 {
+			if i%2 == 0 {
+				even++
+			} else {
+				odd++
+			}
+		}
+	}
+	n := len(data) / 2
+	switch {
+	case odd > n/4 && even < odd/10:
+		return UTF16LE
+	case even > n/4 && odd < even/10:
+		return UTF16BE
+	}
+	return nil
+}
+
+// isText reports whether data decodes to valid text, without control
+// characters or replacement characters in its prefix. Decoding errors,
+// which decode to replacement characters, are not allowed anywhere, except
+// for a few invalid sequences in UTF-8, like a stray Latin-1 byte in a UTF-8
+// file: at most one for every minValidUTF8 valid multibyte sequences.
+// They are kept as they are.
+func (e *Encoding) isText(data []byte, atEOF bool) bool {
+	text, utf := data, e == UTF8 || e == UTF8BOM
+	if !utf {
+		text, _ = e.decode(nil, data, atEOF)
+	}
+	var multibyte, invalid int // UTF-8 sequences
+	for i := 0; i < len(text); {
+		c, size := utf8.DecodeRune(text[i:])
+		if c == utf8.RuneError && size <= 1 {
+			if !atEOF && !utf8.FullRune(text[i:]) {
+				// last char may be incomplete - ignore
+				break
+			}
+			invalid++
+			i++
+			continue
+		}
+		if c == utf8.RuneError && (!utf || i < textLen) {
+			return false
+		}
+		if i < textLen && c < ' ' && c != '\n' && c != '\t' && c != '\f' && c != '\r' {
+			return false
+		}
+		if size > 1 {
+			multibyte++
+		}
+		i += size
+	}
+	return invalid == 0 || utf && multibyte >= minValidUTF8*invalid
+}
+
+// minValidUTF8 is the number of valid multibyte sequences in UTF-8 text
+// for each invalid sequence it may have.
+const minValidUTF8 = 4
+
+// Decode returns data, in the encoding e, decoded to UTF-8, without the
+// byte order mark.
+func (e *Encoding) Decode(data []byte) []byte {
+	data = bytes.TrimPrefix(data, e.bom)
+	if e == UTF8 || e == UTF8BOM {
+		return data
+	}
+	text, _ := e.decode(make([]byte, 0, len(data)), data, true)
+	return text
+}
+
+// NewReader returns a reader of the text read from r, decoded to UTF-8,
+// and its encoding, detected by Detect. If r is not text, the reader reads
+// r as is and the encoding is nil.
+func NewReader(r io.Reader) (io.Reader, *Encoding) {
+	br := bufio.NewReaderSize(r, SniffLen+1)
+	// a prefix of SniffLen bytes or less is examined as all of the data;
+	// read errors are returned by the reader
+	prefix, _ := br.Peek(SniffLen + 1)
+	e := Detect(prefix)
+	switch e {
+	case nil, UTF8:
+		return br, e
+	}
+	if bytes.HasPrefix(prefix, e.bom) {
+		br.Discard(len(e.bom))
+	}
+	if e == UTF8BOM {
+		return br, e
+	}
+	return &reader{r: br, e: e, buf: make([]byte, 32<<10)}, e
+}
+
+// reader decodes the text read from r.
+type reader struct {
+	r   io.Reader
+	e   *Encoding
+	buf []byte // for reading r
+	in  []byte // read but not decoded
+	out []byte // decoded but not read
+	err error
+}
+
+func (d *reader) Read(p []byte) (int, error) {
+	for len(d.out) == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		n, err := d.r.Read(d.buf)
+		d.in = append(d.in, d.buf[:n]...)
+		d.err = err
+		var m int
+		d.out, m = d.e.decode(d.out[:0], d.in, err != nil)
+		d.in = append(d.in[:0], d.in[m:]...)
+	}
+	n := copy(p, d.out)
+	d.out = d.out[n:]
+	return n, nil
+}
+
+func decodeUTF8(dst, src []byte, atEOF bool) ([]byte, int) {
+	return append(dst, src...), len(src)
+}
+
+func decodeUTF16LE(dst, src []byte, atEOF bool) ([]byte, int) {
+	return decodeUTF16(dst, src, atEOF, func(b []byte) uint16 { return uint16(b[0]) | uint16(b[1])<<8 })
+}
+
+func decodeUTF16BE(dst, src []byte, atEOF bool) ([]byte, int) {
+	return decodeUTF16(dst, src, atEOF, func(b []byte) uint16 { return uint16(b[0])<<8 | uint16(b[1]) })
+}
+
+func decodeUTF16(dst, src []byte, atEOF bool, unit func([]byte) uint16) ([]byte, int) {
+	i := 0
+	for ; i+2 <= len(src); i += 2 {
+		c := rune(unit(src[i:]))
+		if utf16.IsSurrogate(c) {
+			if i+4 > len(src) && !atEOF {
+				break
+			}
+			if i+4 <= len(src) {
+				if r := utf16.DecodeRune(c, rune(unit(src[i+2:]))); r != utf8.RuneError {
+					dst = utf8.AppendRune(dst, r)
+					i += 2
+					continue
+				}
+			}
+			c = utf8.RuneError
+		}
+		dst = utf8.AppendRune(dst, c)
+	}
+	if atEOF && i < len(src) {
+		// odd byte at the end
+		dst = utf8.AppendRune(dst, utf8.RuneError)
+		i = len(src)
+	}
+	return dst, i
+}
+
+// windows1252 maps the bytes 0x80-0x9f of Windows-1252 to runes; the
+// other bytes are the runes of Latin-1. Undefined bytes map to RuneError.
+var windows1252 = [32]rune{
+	'€', utf8.RuneError, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', utf8.RuneError, 'Ž', utf8.RuneError,
+	utf8.RuneError, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', utf8.RuneError, 'ž', 'Ÿ',
+}
+
+func decodeWindows1252(dst, src []byte, atEOF bool) ([]byte, int) {
+	for _, b := range src {
+		switch {
+		case b < 0x80:
+			dst = append(dst, b)
+		case b < 0xa0:
+			dst = utf8.AppendRune(dst, windows1252[b-0x80])
+		default:
+			dst = utf8.AppendRune(dst, rune(b))
+		}
+	}
+	return dst, len(src)
+}
@@ -0,0 +1,85 @@
+package charset
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+// utf16Bytes returns s encoded in UTF-16, little endian unless be.
+func utf16Bytes(s string, be bool) []byte {
+	var b []byte
+	for _, u := range utf16.Encode([]rune(s)) {
+		if be {
+			b = append(b, byte(u>>8), byte(u))
+		} else {
+			b = append(b, byte(u), byte(u>>8))
+		}
+	}
+	return b
+}
+
+func name(e *Encoding) string {
+	if e == nil {
+		return "nil"
+	}
+	return e.Name
+}
+
+// longUTF8 is UTF-8 text longer than SniffLen with a character cut by the
+// end of the prefix, and few others.
+var longUTF8 = strings.Repeat("a", SniffLen-1) + "é café\n"
+
+var tests = []struct {
+	name string
+	data string
+	want *Encoding
+	text string // decoded
+}{
+	{"empty", "", UTF8, ""},
+	{"ASCII", "package main\n", UTF8, "package main\n"},
+	{"UTF-8", "café ☕\n", UTF8, "café ☕\n"},
+	{"UTF-8 with BOM", "\xef\xbb\xbfcafé", UTF8BOM, "café"},
+	{"UTF-16LE with BOM", "\xff\xfe" + string(utf16Bytes("café 𝄞", false)), UTF16LE, "café 𝄞"},
+	{"UTF-16BE with BOM", "\xfe\xff" + string(utf16Bytes("café 𝄞", true)), UTF16BE, "café 𝄞"},
+	{"UTF-16LE", string(utf16Bytes("hello, world\n", false)), UTF16LE, "hello, world\n"},
+	{"UTF-16BE", string(utf16Bytes("hello, world\n", true)), UTF16BE, "hello, world\n"},
+	{"Latin-1", "caf\xe9\n", Windows1252, "café\n"},
+	{"Windows-1252", "\x93quoted\x94 \x80 5", Windows1252, "“quoted” € 5"},
+	{"UTF-8 cut at the end", "caf\xc3", Windows1252, "cafÃ"},
+	{"UTF-8 with a stray Latin-1 byte", "é é é é caf\xe9", UTF8, "é é é é caf\xe9"},
+	{"UTF-8 with stray bytes", "é caf\xe9", Windows1252, "Ã© café"},
+	{"UTF-8 longer than SniffLen", longUTF8, UTF8, longUTF8},
+	{"control characters", "\x01\x02text", nil, "\x01\x02text"},
+	{"binary", "\x7fELF\x02\x01\x01\x00\x00\x00", nil, "\x7fELF\x02\x01\x01\x00\x00\x00"},
+}
+
+func TestDetect(t *testing.T) {
+	for _, tt := range tests {
+		if e := Detect([]byte(tt.data)); e != tt.want {
+			t.Errorf("%s: Detect = %s, want %s", tt.name, name(e), name(tt.want))
+		}
+	}
+}
+
+func TestNewReader(t *testing.T) {
+	for _, tt := range tests {
+		r, e := NewReader(strings.NewReader(tt.data))
+		if e != tt.want {
+			t.Errorf("%s: NewReader encoding = %s, want %s", tt.name, name(e), name(tt.want))
+		}
+		text, err := io.ReadAll(r)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if string(text) != tt.text {
+			t.Errorf("%s: NewReader text = %.40q, want %.40q", tt.name, text, tt.text)
+		}
+		if tt.want != nil && !bytes.Equal(tt.want.Decode([]byte(tt.data)), text) {
+			t.Errorf("%s: NewReader text differs from Decode", tt.name)
+		}
+	}
+}
@@ -0,0 +1,457 @@
+// Original: https://github.com/google/codesearch/blob/v1.3.0-rc.1/cmd/cindex/cindex.go
+//
+// Changelog:
+//  - index the cell sources and text outputs of Jupyter notebooks instead of
+//    their JSON
+//  - -zip indexes tar archives and zips with other extensions (jar, whl),
+//    including nested archives, with package archive
+//  - index the decompressed contents of files compressed with gzip or bzip2
+//  - index text in UTF-16 and Windows-1252 (Latin-1) decoded to UTF-8, with
+//    package charset
+//  - index files with lines longer than the index allows, like minified code
+//
+// Original notice:
+//  Copyright 2011 The Go Authors.  All rights reserved.
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime/pprof"
+	"slices"
+	"unicode/utf8"
+
+	"github.com/google/codesearch/index"
+	"github.com/touchmarine/sandd/archive"
+	"github.com/touchmarine/sandd/charset"
+	"github.com/touchmarine/sandd/notebook"
+)
+
+var usageMessage = `usage: csindex [-list] [-reset] [-zip] [path...]
+
+Csindex prepares the trigram index for use by csearch and csweb.  The index is the
+file named by $CSEARCHINDEX, or else $HOME/.csearchindex.
+
+The simplest invocation is
+
+	csindex path...
+
+which adds the file or directory tree named by each path to the index.
+For example:
+
+	csindex $HOME/src /usr/include
+
+or, equivalently:
+
+	csindex $HOME/src
+	csindex /usr/include
+
+If csindex is invoked with no paths, it reindexes the paths that have
+already been added, in case the files have changed.  Thus, 'csindex' by
+itself is a useful command to run in a nightly cron job.
+
+The -list flag causes csindex to list the paths it has indexed and exit.
+
+The -zip flag causes csindex to index content inside archives: zip
+files (including .jar and .whl files) and tar files, uncompressed or
+compressed with gzip or bzip2, including archives nested in them.
+A file in an archive is named by the archive, "!/" and its path in the
+archive, like "src.tar.gz!/src/main.go". Indexes made by cindex -zip, or
+by csindex before it named them so, name files in zip files like
+"src.zip\x01main.go"; csweb reads both, and running csindex -zip again
+renames them.
+
+By default csindex adds the named paths to the index but preserves
+information about other paths that might already be indexed
+(the ones printed by csindex -list).  The -reset flag causes csindex to
+delete the existing index before indexing the new paths.
+With no path arguments, csindex -reset removes the index.
+
+Jupyter notebooks (.ipynb files) are indexed by the text of their cells
+and outputs rather than their JSON, which is how csweb searches them.
+
+Files compressed with gzip or bzip2 (.gz and .bz2 files, other than tar
+archives) are indexed by their decompressed contents.
+
+Text in other encodings than UTF-8, detected by its byte order mark or
+else by its contents, is indexed decoded to UTF-8: UTF-16 and
+Windows-1252, which includes Latin-1.
+
+Files with very long lines, like minified code and single-line JSON, are
+indexed too, unless they have too many distinct trigrams to be text.
+`
+
+func usage() {
+	fmt.Fprint(os.Stderr, usageMessage)
+	os.Exit(2)
+}
+
+var (
+	listFlag    = flag.Bool("list", false, "list indexed paths and exit")
+	resetFlag   = flag.Bool("reset", false, "discard existing index")
+	verboseFlag = flag.Bool("verbose", false, "print extra information")
+	cpuProfile  = flag.String("cpuprofile", "", "write cpu profile to this file")
+	checkFlag   = flag.Bool("check", false, "check index is well-formatted")
+	zipFlag     = flag.Bool("zip", false, "index content in archives")
+	statsFlag   = flag.Bool("stats", false, "print index size statistics")
+)
+
+func main() {
+	log.SetPrefix("csindex: ")
+	flag.Usage = usage
+	flag.Parse()
+
+	if *listFlag {
+		ix := index.Open(index.File())
+		if *checkFlag {
+			if err := ix.Check(); err != nil {
+				log.Fatal(err)
+			}
+		}
+		for p := range ix.Roots().All() {
+			fmt.Printf("%s\n", p)
+		}
+		return
+	}
+
+	if *cpuProfile != "" {
+		f, err := os.Create(*cpuProfile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		pprof.StartCPUProfile(f)
+		defer pprof.StopCPUProfile()
+	}
+
+	if *resetFlag && flag.NArg() == 0 {
+		os.Remove(index.File())
+		return
+	}
+	var roots []index.Path
+	if flag.NArg() == 0 {
+		ix := index.Open(index.File())
+		roots = slices.Collect(ix.Roots().All())
+	} else {
+		// Translate arguments to absolute paths so that
+		// we can generate the file list in sorted order.
+		for _, arg := range flag.Args() {
+			a, err := filepath.Abs(arg)
+			if err != nil {
+				log.Printf("%s: %s", arg, err)
+				continue
+			}
+			roots = append(roots, index.MakePath(a))
+		}
+		slices.SortFunc(roots, index.Path.Compare)
+	}
+
+	master := index.File()
+	if _, err := os.Stat(master); err != nil {
+		// Does not exist.
+		*resetFlag = true
+	}
+	file := master
+	if !*resetFlag {
+		file += "~"
+		if *checkFlag {
+			ix := index.Open(master)
+			if err := ix.Check(); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+
+	ix := index.Create(file)
+	ix.Verbose = *verboseFlag
+	ix.AddRoots(roots)
+	for _, root := range roots {
+		log.Printf("index %s", root)
+		filepath.Walk(root.String(), func(path string, info os.FileInfo, err error) error {
+			if _, elem := filepath.Split(path); elem != "" {
+				// Skip various temporary or "hidden" files or directories.
+				if elem[0] == '.' || elem[0] == '#' || elem[0] == '~' || elem[len(elem)-1] == '~' {
+					if info.IsDir() {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+			}
+			if err != nil {
+				log.Printf("%s: %s", path, err)
+				return nil
+			}
+			if info != nil && info.Mode()&os.ModeType == 0 {
+				if err := addFile(ix, path); err != nil {
+					log.Printf("%s: %s", path, err)
+					return nil
+				}
+			}
+			return nil
+		})
+	}
+	pending.flush(ix, "")
+	log.Printf("flush index")
+	ix.Flush()
+
+	if !*resetFlag {
+		log.Printf("merge %s %s", master, file)
+		index.Merge(file+"~", master, file)
+		if *checkFlag {
+			ix := index.Open(file + "~")
+			if err := ix.Check(); err != nil {
+				log.Fatal(err)
+			}
+		}
+		os.Remove(file)
+		os.Rename(file+"~", master)
+	} else {
+		if *checkFlag {
+			ix := index.Open(file)
+			if err := ix.Check(); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+
+	log.Printf("done")
+
+	if *statsFlag {
+		ix := index.Open(master)
+		ix.PrintStats()
+	}
+	return
+}
+
+// addFile adds the named file to the index (see add).
+func addFile(ix *index.IndexWriter, name string) error {
+	pending.flush(ix, name)
+	if *zipFlag && archive.Is(name) {
+		return pending.add(name)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return add(ix, name, f)
+}
+
+// pending are the files in archives waiting to be added to the index.
+//
+// The index requires files to be added in the order of their names, but
+// files in an archive may sort after files that filepath.Walk visits after
+// the archive ("a.zip!/f" sorts after "a.zip copy"), so they are added
+// when the walk passes them.
+var pending archiveFiles
+
+type archiveFiles struct {
+	files []archiveFile // sorted by name
+	open  []*archive.FS // archives to close when files is empty
+}
+
+type archiveFile struct {
+	name string
+	arch *archive.FS
+	path string
+}
+
+// add adds the files in the named archive to the pending files.
+func (p *archiveFiles) add(name string) error {
+	a, err := archive.Open(name)
+	if err != nil {
+		return err
+	}
+	p.open = append(p.open, a)
+	archive.Walk(a, func(name string, arch *archive.FS, path string, err error) error {
+		if err != nil {
+			log.Printf("%s: %v", name, err)
+			return nil
+		}
+		p.files = append(p.files, archiveFile{name, arch, path})
+		return nil
+	})
+	slices.SortStableFunc(p.files, func(x, y archiveFile) int {
+		return index.MakePath(x.name).Compare(index.MakePath(y.name))
+	})
+	return nil
+}
+
+// flush adds the pending files that sort before the name next, or all of
+// them if next is "".
+func (p *archiveFiles) flush(ix *index.IndexWriter, next string) {
+	n := 0
+	for ; n < len(p.files); n++ {
+		f := p.files[n]
+		if next != "" && index.MakePath(f.name).Compare(index.MakePath(next)) >= 0 {
+			break
+		}
+		if n+1 < len(p.files) && p.files[n+1].name == f.name {
+			// replaced by a later file of the same name
+			continue
+		}
+		r, err := f.arch.Open(f.path)
+		if err != nil {
+			log.Printf("%s: %v", f.name, err)
+			continue
+		}
+		if err := add(ix, f.name, r); err != nil {
+			log.Printf("%s: %v", f.name, err)
+		}
+		r.Close()
+	}
+	p.files = p.files[n:]
+	if len(p.files) == 0 {
+		for _, a := range p.open {
+			a.Close()
+		}
+		p.open = nil
+	}
+}
+
+// add adds the file with contents r. Compressed files are added by their
+// decompressed contents and text by its text decoded to UTF-8. Notebooks
+// are added by their text; if a notebook cannot be parsed, it is added as
+// is.
+func add(ix *index.IndexWriter, name string, r io.Reader) error {
+	if archive.IsCompressed(name) {
+		var err error
+		if r, err = archive.Decompress(name, r); err != nil {
+			return err
+		}
+	}
+	r, enc := charset.NewReader(r)
+	if enc != nil {
+		r = &lineSplitter{r: r, buf: make([]byte, 32<<10)}
+	}
+	if !notebook.IsNotebook(archive.Decompressed(name)) {
+		return ix.Add(name, r)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	nb, err := notebook.Parse(data)
+	if err != nil {
+		if *verboseFlag {
+			log.Printf("%s: %v", name, err)
+		}
+		return ix.Add(name, bytes.NewReader(data))
+	}
+	text, _ := nb.Text(true)
+	return ix.Add(name, bytes.NewReader(text))
+}
+
+// splitLineLen is the length of the lines lineSplitter breaks longer lines
+// into, well below the longest line the index accepts (2000 bytes, counting
+// the newline); files with longer lines are skipped by index.IndexWriter.Add.
+const splitLineLen = 1000
+
+// lineSplitter reads r, UTF-8 text, with lines longer than splitLineLen
+// broken into shorter lines for the index. Each of them begins with the
+// characters ending the previous one, at least two bytes, so that the
+// trigrams spanning the break are kept; the trigrams of the newline added
+// match nothing a search could not match anyway.
+type lineSplitter struct {
+	r       io.Reader
+	buf     []byte // for reading r
+	carry   int    // bytes at the start of buf read but not split
+	out     []byte // split but not read
+	err     error
+	linelen int    // length of the current line, without newline
+	tail    []byte // up to the last 8 bytes of the current line
+}
+
+func (s *lineSplitter) Read(p []byte) (int, error) {
+	for len(s.out) == 0 {
+		if s.err != nil {
+			return 0, s.err
+		}
+		n, err := s.r.Read(s.buf[s.carry:])
+		s.err = err
+		data := s.buf[:s.carry+n]
+		// Split whole characters, keeping one cut off by the read
+		// for the next.
+		s.carry = 0
+		if err == nil {
+			s.carry = incompleteRune(data)
+		}
+		s.out = s.split(s.out[:0], data[:len(data)-s.carry])
+		copy(s.buf, data[len(data)-s.carry:])
+	}
+	n := copy(p, s.out)
+	s.out = s.out[n:]
+	return n, nil
+}
+
+// split appends src to dst, breaking long lines.
+func (s *lineSplitter) split(dst, src []byte) []byte {
+	for len(src) > 0 {
+		seg := src
+		if i := bytes.IndexByte(src, '\n'); i >= 0 {
+			seg = src[:i+1]
+		}
+		src = src[len(seg):]
+		for s.linelen+len(seg) > splitLineLen {
+			// break before a character, as the index rejects
+			// invalid UTF-8; one starts within utf8.UTFMax bytes
+			// unless the line is not valid UTF-8 anyway
+			n := splitLineLen - s.linelen
+			for i := n; i >= 0 && i > n-utf8.UTFMax; i-- {
+				if utf8.RuneStart(seg[i]) {
+					n = i
+					break
+				}
+			}
+			dst = append(dst, seg[:n]...)
+			s.keep(seg[:n])
+			i := max(len(s.tail)-2, 0)
+			for i > 0 && !utf8.RuneStart(s.tail[i]) {
+				i--
+			}
+			dst = append(dst, '\n')
+			dst = append(dst, s.tail[i:]...)
+			s.linelen = len(s.tail) - i
+			seg = seg[n:]
+		}
+		dst = append(dst, seg...)
+		if seg[len(seg)-1] == '\n' {
+			s.linelen = 0
+			s.tail = s.tail[:0]
+		} else {
+			s.keep(seg)
+		}
+	}
+	return dst
+}
+
+// incompleteRune returns the length of the incomplete character at the end
+// of b, if any.
+func incompleteRune(b []byte) int {
+	for i := len(b) - 1; i >= 0 && i >= len(b)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(b[i]) {
+			if utf8.FullRune(b[i:]) {
+				return 0
+			}
+			return len(b) - i
+		}
+	}
+	return 0
+}
+
+// keep records b as the end of the current line.
+func (s *lineSplitter) keep(b []byte) {
+	s.linelen += len(b)
+	s.tail = append(s.tail, b[max(0, len(b)-8):]...)
+	if len(s.tail) > 8 {
+		s.tail = append(s.tail[:0], s.tail[len(s.tail)-8:]...)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"unicode/utf8"
+)
+
+// chunkReader reads r n bytes at a time.
+type chunkReader struct {
+	r io.Reader
+	n int
+}
+
+func (c chunkReader) Read(p []byte) (int, error) {
+	return c.r.Read(p[:min(len(p), c.n)])
+}
+
+func TestLineSplitter(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+	}{
+		{"short lines", "package main\n\nfunc main() {}\n"},
+		{"long line", strings.Repeat("abcdefghij", 350) + "\nend\n"},
+		{"long line without newline", strings.Repeat("x", 2500)},
+		{"line of splitLineLen", strings.Repeat("y", splitLineLen) + "\n" + strings.Repeat("z", splitLineLen)},
+		{"two-byte characters", strings.Repeat("é", 1500) + "\n"},
+		{"three-byte characters", "a" + strings.Repeat("€", 1200)},
+		{"four-byte characters", strings.Repeat("𝄞b", 700) + "\n" + strings.Repeat("𝄞", 600)},
+		{"mixed", strings.Repeat("naïve café 😀 ", 300)},
+		{"continuation bytes", strings.Repeat("\x80", 2500) + "\nok\n"},
+		{"continuation bytes after text", strings.Repeat("w", 990) + strings.Repeat("\xbf", 1500)},
+	}
+	readers := []struct {
+		name string
+		wrap func(io.Reader) io.Reader
+	}{
+		{"whole", func(r io.Reader) io.Reader { return r }},
+		{"one byte", iotest.OneByteReader},
+		{"chunks", func(r io.Reader) io.Reader { return chunkReader{r, 997} }},
+	}
+	for _, tt := range tests {
+		for _, rd := range readers {
+			r := &lineSplitter{r: rd.wrap(strings.NewReader(tt.text)), buf: make([]byte, 4<<10)}
+			out, err := io.ReadAll(r)
+			if err != nil {
+				t.Errorf("%s, %s reads: %v", tt.name, rd.name, err)
+				continue
+			}
+			if utf8.ValidString(tt.text) && !utf8.Valid(out) {
+				t.Errorf("%s, %s reads: invalid UTF-8 in the output", tt.name, rd.name)
+			}
+			for i, line := range bytes.Split(out, []byte("\n")) {
+				if len(line) > splitLineLen {
+					t.Errorf("%s, %s reads: line %d is %d bytes long", tt.name, rd.name, i+1, len(line))
+				}
+			}
+			// The trigrams of the text are kept.
+			for i := 0; i+3 <= len(tt.text); i++ {
+				tri := tt.text[i : i+3]
+				if !strings.Contains(tri, "\n") && !bytes.Contains(out, []byte(tri)) {
+					t.Errorf("%s, %s reads: trigram %q at %d lost", tt.name, rd.name, tri, i)
+					break
+				}
+			}
+			if len(tt.text) <= splitLineLen && string(out) != tt.text {
+				t.Errorf("%s, %s reads: text changed: %q", tt.name, rd.name, out)
+			}
+		}
+	}
+}
@@ -0,0 +1,23 @@
+document.querySelectorAll('[data-cur-dir]').forEach((btn) => {
+    btn.addEventListener('click', () => {
+        const input = document.getElementById('file')
+        input.value = btn.dataset.curDir
+    })
+})
+document.querySelectorAll('[data-ext-pattern]').forEach((btn) => {
+    btn.addEventListener('click', () => {
+        const input = document.getElementById('file')
+        input.value = btn.dataset.extPattern
+    })
+})
+document.getElementById('query').addEventListener('paste', (e) => {
+    // Browsers drop the newlines of text pasted into one-line inputs,
+    // joining the lines pasted; keep them as spaces for Ignore Whitespace.
+    const text = e.clipboardData.getData('text')
+    if (!text.includes('\n')) {
+        return
+    }
+    e.preventDefault()
+    const input = e.target
+    input.setRangeText(text.replace(/\r?\n/g, ' '), input.selectionStart, input.selectionEnd, 'end')
+})
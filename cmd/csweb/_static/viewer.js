@@ -1,8 +1,26 @@
 function highlight() {
 	if(window.location.hash) {
-		var span = document.getElementById(window.location.hash.substr(1));
+		var id = window.location.hash.substr(1);
+		var span = document.getElementById(id);
+		if(!span && /^cell-\d+-/.test(id)) {
+			// lines of rendered notebook cells have no anchors
+			span = document.getElementById(id.replace(/^(cell-\d+)-.*/, "$1"));
+			if(span) {
+				span.scrollIntoView();
+			}
+		}
 		if(span) {
 			span.classList.add("sel");
 		}
 	}
 }
+
+window.addEventListener("load", highlight);
+
+// Line anchors refer to the source view of rendered documents.
+window.addEventListener("DOMContentLoaded", function() {
+	var doc = document.querySelector("[data-source]");
+	if(doc && /^#L\d+$/.test(window.location.hash)) {
+		window.location.replace(doc.dataset.source + window.location.hash);
+	}
+});
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	goregexp "regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/google/codesearch/index"
+	"github.com/google/codesearch/regexp"
+	"github.com/touchmarine/sandd/codesearchpatch"
+)
+
+// A queryExpr is a search query: a term, or terms combined with AND, OR and
+// NOT, like "sql.Open AND context.Background". Documents match a
+// combination if their text does, on any lines: "a AND b" matches the
+// documents containing both a and b, "a AND NOT b" those containing a but
+// not b. AND takes precedence over OR.
+//
+// A term may have a proximity condition on its lines: "a NEAR/5 b" matches
+// the lines of a with b within 5 lines of them, before or after, and
+// "a NOT NEAR/5 b" those without. NEAR alone is NEAR/3.
+//
+// Queries without operators, like "IS NOT NULL", are a single term. Terms
+// containing operators are quoted, like "x AND y" OR z.
+type queryExpr struct {
+	terms   []queryTerm
+	clauses [][]exprTerm // ORed clauses of ANDed terms
+}
+
+// A queryTerm is a term of a query.
+type queryTerm struct {
+	text    string                  // as entered
+	pat     string                  // without quotes and proximity condition
+	nearPat string                  // of near
+	re      *regexp.Regexp          // not safe for concurrent use, unlike engine
+	near    *codesearchpatch.Near   // if not nil, a condition on the lines of re
+	engine  *codesearchpatch.Engine // searching for re and near, without limits
+}
+
+// An exprTerm is a term of a clause of a query.
+type exprTerm struct {
+	term int // index in queryExpr.terms
+	not  bool
+}
+
+// exprOp matches the binary operators of queries, between terms.
+var exprOp = goregexp.MustCompile(`\s(?:AND|OR|(?:NOT\s+)?NEAR(?:/\d+)?)(?:\s|$)`)
+
+// nearOp matches the proximity operator at the start of a query, with the
+// distance in lines, if any.
+var nearOp = goregexp.MustCompile(`^(NOT\s+)?NEAR(?:/(\d+))?(?:\s+|$)`)
+
+// defaultNear is the distance in lines of NEAR without one.
+const defaultNear = 3
+
+// parseQuery returns the query qarg with the options o.
+func parseQuery(qarg string, o queryOptions) (*queryExpr, error) {
+	qarg, o.Case = queryCase(qarg, o.Case)
+	x := &queryExpr{
+		terms:   []queryTerm{{text: qarg, pat: qarg}},
+		clauses: [][]exprTerm{{{term: 0}}},
+	}
+	if exprOp.MatchString(qarg) {
+		var err error
+		x.terms, x.clauses, err = parseExpr(qarg)
+		if err != nil {
+			return nil, fmt.Errorf("bad query: %v", err)
+		}
+	}
+	for i := range x.terms {
+		t := &x.terms[i]
+		var err error
+		t.re, err = termRegexp(t.pat, o)
+		if err == nil && t.near != nil {
+			t.near.Regexp, err = termRegexp(t.nearPat, o)
+		}
+		if err != nil {
+			if len(x.terms) > 1 || t.near != nil {
+				return nil, fmt.Errorf("bad query: %s: %v", t.text, err)
+			}
+			return nil, fmt.Errorf("bad query: %v", err)
+		}
+		t.engine = codesearchpatch.NewEngine(t.re, codesearchpatch.EngineConfig{
+			Near:      t.near,
+			Multiline: o.Space,
+		})
+	}
+	return x, nil
+}
+
+// parseExpr returns the terms, without regexps, and clauses of the query q.
+func parseExpr(q string) (terms []queryTerm, clauses [][]exprTerm, err error) {
+	var clause []exprTerm
+	for s := strings.TrimSpace(q); ; {
+		not := false
+		if rest, ok := cutWord(s, "NOT"); ok {
+			not, s = true, rest
+		}
+		start := s
+		var t queryTerm
+		if t.pat, s, err = cutTerm(s); err != nil {
+			return nil, nil, err
+		}
+		s = strings.TrimLeftFunc(s, unicode.IsSpace)
+		if m := nearOp.FindStringSubmatch(s); m != nil {
+			t.near = &codesearchpatch.Near{Lines: defaultNear, Not: m[1] != ""}
+			if m[2] != "" {
+				if t.near.Lines, err = strconv.Atoi(m[2]); err != nil {
+					return nil, nil, fmt.Errorf("bad NEAR distance: %v", err)
+				}
+			}
+			if t.nearPat, s, err = cutTerm(s[len(m[0]):]); err != nil {
+				return nil, nil, err
+			}
+		}
+		t.text = strings.TrimSpace(start[:len(start)-len(s)])
+		clause = append(clause, exprTerm{len(terms), not})
+		terms = append(terms, t)
+
+		s = strings.TrimLeftFunc(s, unicode.IsSpace)
+		if s == "" {
+			break
+		}
+		if rest, ok := cutWord(s, "AND"); ok {
+			s = rest
+		} else if rest, ok := cutWord(s, "OR"); ok {
+			clauses = append(clauses, clause)
+			clause, s = nil, rest
+		} else {
+			return nil, nil, fmt.Errorf("AND or OR expected after %q", t.text)
+		}
+	}
+	clauses = append(clauses, clause)
+	for _, c := range clauses {
+		if !slices.ContainsFunc(c, func(t exprTerm) bool { return !t.not }) {
+			// The index can't find the documents without a term.
+			return nil, nil, errors.New("NOT needs a term that is not negated, like a AND NOT b")
+		}
+	}
+	return terms, clauses, nil
+}
+
+// cutTerm returns the term at the start of s, quoted or up to the next
+// operator, and the rest of s.
+func cutTerm(s string) (term, rest string, err error) {
+	if len(s) > 1 && s[0] == '"' && strings.Contains(s[1:], `"`) {
+		term, rest, _ = strings.Cut(s[1:], `"`)
+	} else {
+		end := len(s)
+		if loc := exprOp.FindStringIndex(s); loc != nil {
+			end = loc[0]
+		}
+		term, rest = strings.TrimSpace(s[:end]), s[end:]
+	}
+	if term == "" {
+		return "", "", errors.New("missing term")
+	}
+	return term, rest, nil
+}
+
+// cutWord returns s without the word w and the space after it, and
+// reports whether s begins with the word.
+func cutWord(s, w string) (string, bool) {
+	rest, ok := strings.CutPrefix(s, w)
+	if !ok {
+		return s, false
+	}
+	if r, _ := utf8.DecodeRuneInString(rest); rest != "" && !unicode.IsSpace(r) {
+		return s, false
+	}
+	return strings.TrimLeftFunc(rest, unicode.IsSpace), true
+}
+
+// indexQuery returns the index query for the documents that may match: the
+// union of the intersections of the queries of the terms of the clauses,
+// and of their NEAR terms. Negated terms do not narrow it down; the index
+// lists the documents that may contain a term, not those that do.
+func (x *queryExpr) indexQuery() *index.Query {
+	or := &index.Query{Op: index.QOr}
+	for _, c := range x.clauses {
+		and := &index.Query{Op: index.QAnd}
+		for _, t := range c {
+			if t.not {
+				continue
+			}
+			term := &x.terms[t.term]
+			and.Sub = append(and.Sub, index.RegexpQuery(term.re.Syntax))
+			if term.near != nil && !term.near.Not {
+				and.Sub = append(and.Sub, index.RegexpQuery(term.near.Regexp.Syntax))
+			}
+		}
+		if len(and.Sub) == 1 {
+			and = and.Sub[0]
+		}
+		or.Sub = append(or.Sub, and)
+	}
+	if len(or.Sub) == 1 {
+		return or.Sub[0]
+	}
+	return or
+}
+
+// matchTerms returns the terms, not negated, of the clauses that the text
+// satisfies, or nil if it satisfies none.
+func (x *queryExpr) matchTerms(text []byte) []int {
+	has := make(map[int]bool)
+	contains := func(t int) bool {
+		c, ok := has[t]
+		if !ok {
+			c = x.terms[t].match(text)
+			has[t] = c
+		}
+		return c
+	}
+	var terms []int
+	for _, c := range x.clauses {
+		if slices.ContainsFunc(c, func(t exprTerm) bool { return contains(t.term) == t.not }) {
+			continue
+		}
+		for _, t := range c {
+			if !t.not && !slices.Contains(terms, t.term) {
+				terms = append(terms, t.term)
+			}
+		}
+	}
+	return terms
+}
+
+// match reports whether the text matches the query.
+func (x *queryExpr) match(text []byte) bool {
+	return x.matchTerms(text) != nil
+}
+
+// matchLines returns the numbers of the lines of the text that the terms of
+// the query that are not negated match.
+func (x *queryExpr) matchLines(text []byte) map[int]bool {
+	lines := make(map[int]bool)
+	for _, c := range x.clauses {
+		for _, t := range c {
+			if t.not {
+				continue
+			}
+			res, _ := x.terms[t.term].engine.Search(context.Background(), bytes.NewReader(text), "")
+			for _, m := range res.Matches {
+				for n := m.Lineno; n <= m.EndLineno; n++ {
+					lines[n] = true
+				}
+			}
+		}
+	}
+	return lines
+}
+
+// match reports whether the text matches the term. It is safe for
+// concurrent use.
+func (t *queryTerm) match(text []byte) bool {
+	ok, _ := t.engine.Match(context.Background(), bytes.NewReader(text), "")
+	return ok
+}
+
+// A termResult is the result of the search for a term of a query.
+type termResult struct {
+	term string // of a query of several terms, or ""
+	*codesearchpatch.Result
+}
+
+// searchExpr searches r, the text of the named document, for the query x
+// with the engines of its terms.
+func searchExpr(ctx context.Context, x *queryExpr, engines []*codesearchpatch.Engine, r io.Reader, name string) ([]termResult, error) {
+	if len(x.terms) == 1 {
+		res, err := engines[0].Search(ctx, r, name)
+		return []termResult{{"", res}}, err
+	}
+	// Whether the document matches is known after reading all of it.
+	text, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var rs []termResult
+	for _, t := range x.matchTerms(text) {
+		res, err := engines[t].Search(ctx, bytes.NewReader(text), name)
+		rs = append(rs, termResult{x.terms[t].text, res})
+		if err != nil {
+			return rs, err
+		}
+	}
+	return rs, nil
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// describe returns the clauses of x, with the patterns of their terms, like
+// "(a NEAR/3 b AND NOT c) OR (d)".
+func describe(x *queryExpr) string {
+	var clauses []string
+	for _, c := range x.clauses {
+		var terms []string
+		for _, t := range c {
+			term := x.terms[t.term]
+			s := strings.TrimPrefix(term.re.String(), "(?m)")
+			if term.near != nil {
+				op := "NEAR"
+				if term.near.Not {
+					op = "NOT NEAR"
+				}
+				s += fmt.Sprintf(" %s/%d %s", op, term.near.Lines, term.nearPat)
+			}
+			if t.not {
+				s = "NOT " + s
+			}
+			terms = append(terms, s)
+		}
+		clauses = append(clauses, "("+strings.Join(terms, " AND ")+")")
+	}
+	return strings.Join(clauses, " OR ")
+}
+
+// The queries of the tests are case-sensitive, for short regexps.
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		q, want string
+		regex   bool
+		err     string // if not "", in the error
+	}{
+		{q: "foo", want: "(foo)"},
+		{q: "IS NOT NULL", want: "(IS NOT NULL)"},
+		{q: "NOT foo", want: "(NOT foo)"},
+		{q: "OR foo", want: "(OR foo)"},
+		{q: "a.b(", want: `(a\.b\()`},
+		{q: "foo AND bar", want: "(foo AND bar)"},
+		{q: "foo OR bar", want: "(foo) OR (bar)"},
+		{q: "foo AND bar OR baz", want: "(foo AND bar) OR (baz)"},
+		{q: "foo OR bar AND baz", want: "(foo) OR (bar AND baz)"},
+		{q: "foo AND NOT bar", want: "(foo AND NOT bar)"},
+		{q: "NOT foo AND bar OR baz AND NOT qux", want: "(NOT foo AND bar) OR (baz AND NOT qux)"},
+		{q: "  foo \t AND\nbar  ", want: "(foo AND bar)"},
+		{q: "foo ANDbar OR baz", want: "(foo ANDbar) OR (baz)"},
+		{q: `"foo AND bar" OR baz`, want: "(foo AND bar) OR (baz)"},
+		{q: `"foo OR bar"`, want: "(foo OR bar)"},
+		{q: `"NOT foo" AND bar`, want: "(NOT foo AND bar)"},
+		{q: `"unclosed AND bar`, want: `("unclosed AND bar)`},
+		{q: "NOT foo OR bar", err: "NOT needs a term that is not negated"},
+		{q: "foo AND NOT bar OR NOT baz", err: "NOT needs a term that is not negated"},
+		{q: "NOT foo AND NOT bar", err: "NOT needs a term that is not negated"},
+		{q: "foo AND", err: "missing term"},
+		{q: `"" OR bar`, err: "missing term"},
+		{q: `"foo" bar AND baz`, err: `AND or OR expected after "\"foo\""`},
+		{q: "a.b( AND bar", regex: true, err: "a.b(: "},
+		{q: "a.b( AND bar", want: `(a\.b\( AND bar)`},
+		{q: "a NEAR b", want: "(a NEAR/3 b)"},
+		{q: "a NEAR/5 b", want: "(a NEAR/5 b)"},
+		{q: "a NOT NEAR/0 b AND c", want: "(a NOT NEAR/0 b AND c)"},
+		{q: `"x OR y" NEAR/2 "z AND w" OR v`, want: "(x OR y NEAR/2 z AND w) OR (v)"},
+		{q: "NOT a NEAR b AND c", want: "(NOT a NEAR/3 b AND c)"},
+		{q: "a NEARby", want: "(a NEARby)"},
+		{q: "a NEAR/99999999999999999999 b", err: "bad NEAR distance"},
+		{q: "a NEAR", err: "missing term"},
+		{q: "a NEAR b NEAR c", err: "AND or OR expected"},
+	}
+	for _, tt := range tests {
+		x, err := parseQuery(tt.q, queryOptions{Case: caseSensitive, Regex: tt.regex})
+		switch {
+		case err != nil && (tt.err == "" || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("parseQuery(%q): %v", tt.q, err)
+		case err == nil && tt.err != "":
+			t.Errorf("parseQuery(%q) = %s, want error %q", tt.q, describe(x), tt.err)
+		case err == nil && describe(x) != tt.want:
+			t.Errorf("parseQuery(%q) = %s, want %s", tt.q, describe(x), tt.want)
+		}
+	}
+}
+
+func TestIndexQuery(t *testing.T) {
+	tests := []struct {
+		q, want string
+	}{
+		{"foo", `"foo"`},
+		{"foo AND bar", `"foo" "bar"`},
+		{"foo AND bar OR baz", `("foo" "bar")|("baz")`},
+		{"foo OR bar AND baz", `("foo")|("bar" "baz")`},
+		// negated terms do not narrow the documents down
+		{"foo AND NOT bar", `"foo"`},
+		{"foo AND NOT bar OR baz", `("foo")|("baz")`},
+		{"fo AND bar", `+ "bar"`},
+		{"foo NEAR bar", `"foo" "bar"`},
+		{"foo NOT NEAR bar", `"foo"`},
+		{"foo NEAR/1 bar OR baz", `("foo" "bar")|("baz")`},
+		{"NOT foo NEAR bar AND baz", `"baz"`},
+	}
+	for _, tt := range tests {
+		x, err := parseQuery(tt.q, queryOptions{Case: caseSensitive})
+		if err != nil {
+			t.Errorf("parseQuery(%q): %v", tt.q, err)
+			continue
+		}
+		if got := x.indexQuery().String(); got != tt.want {
+			t.Errorf("%q: index query %s, want %s", tt.q, got, tt.want)
+		}
+	}
+}
+
+func TestMatchTerms(t *testing.T) {
+	tests := []struct {
+		q, text string
+		want    []int // terms matched, nil if the text does not match
+	}{
+		{"foo AND bar", "foo\nbar\n", []int{0, 1}},
+		{"foo AND bar", "foo\n", nil},
+		{"foo OR bar", "bar\n", []int{1}},
+		{"foo OR bar", "foo bar", []int{0, 1}},
+		{"foo OR bar", "baz", nil},
+		{"foo AND NOT bar", "foo\n", []int{0}},
+		{"foo AND NOT bar", "foo\nbar\n", nil},
+		{"foo AND bar OR baz", "baz", []int{2}},
+		{"foo AND bar OR baz", "foo baz", []int{2}},
+		{"foo AND bar OR baz", "bar\nbaz\nfoo", []int{0, 1, 2}},
+		{"foo OR bar AND baz", "bar", nil},
+		{"foo OR bar AND baz", "baz bar", []int{1, 2}},
+		{"foo AND NOT bar OR baz", "foo bar baz", []int{2}},
+		{"Foo AND bar", "foo bar", nil},
+		{"open NEAR/1 close", "open\nx\nclose\n", nil},
+		{"open NEAR/1 close", "open\nclose\n", []int{0}},
+		{"open NEAR/0 close", "close open\n", []int{0}},
+		{"open NOT NEAR/1 close", "open\nclose\n", nil},
+		{"open NOT NEAR/1 close", "open\nx\nclose\nopen\n", []int{0}},
+		{"open NEAR close AND NOT done", "open\nclose\ndone\n", nil},
+	}
+	for _, tt := range tests {
+		x, err := parseQuery(tt.q, queryOptions{Case: caseSensitive})
+		if err != nil {
+			t.Errorf("parseQuery(%q): %v", tt.q, err)
+			continue
+		}
+		got := x.matchTerms([]byte(tt.text))
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: matchTerms(%q) = %v, want %v", tt.q, tt.text, got, tt.want)
+		}
+		if x.match([]byte(tt.text)) != (tt.want != nil) {
+			t.Errorf("%q: match(%q) = %v", tt.q, tt.text, tt.want == nil)
+		}
+	}
+}
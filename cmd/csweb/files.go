@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"time"
+
+	"github.com/touchmarine/sandd/archive"
+)
+
+func init() {
+	registerSource(fileSource{})
+	registerSource(archiveSource{})
+}
+
+// fileSource provides the indexed files.
+type fileSource struct{}
+
+func (fileSource) List(q *Query) ([]string, error) {
+	var names []string
+	for _, id := range q.Posting() {
+		name := archive.FromLegacy(q.Index().Name(id).String())
+		if _, _, ok := archive.Split(name); !ok {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+func (fileSource) Open(q *Query, name string) (*Document, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return openFile(q, name, f)
+}
+
+func (fileSource) Link(name string) string {
+	return showURL(name)
+}
+
+func (fileSource) ModTime(q *Query, name string) (time.Time, error) {
+	fi, err := os.Stat(name)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return fi.ModTime(), nil
+}
+
+// archiveSource provides the indexed files in archives (csindex -zip).
+type archiveSource struct{}
+
+func (archiveSource) List(q *Query) ([]string, error) {
+	var names []string
+	for _, id := range q.Posting() {
+		name := archive.FromLegacy(q.Index().Name(id).String())
+		if _, _, ok := archive.Split(name); ok {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+func (archiveSource) Open(q *Query, name string) (*Document, error) {
+	a, file, err := openArchive(q, name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := a.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	return openFile(q, name, f)
+}
+
+func (archiveSource) Link(name string) string {
+	return showURL(name)
+}
+
+func (archiveSource) ModTime(q *Query, name string) (time.Time, error) {
+	a, file, err := openArchive(q, name)
+	if err != nil {
+		return time.Time{}, err
+	}
+	fi, err := fs.Stat(a, file)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return fi.ModTime(), nil
+}
+
+// lastArchive is the archive last opened by a query. Names are listed in
+// order, so the files of an archive are opened one after another.
+type lastArchive struct {
+	*archive.FS
+}
+
+type lastArchiveKey struct{}
+
+func (l *lastArchive) Close() error {
+	if l.FS == nil {
+		return nil
+	}
+	return l.FS.Close()
+}
+
+// openArchive returns the innermost archive containing the named file and
+// the path of the file in it.
+func openArchive(q *Query, name string) (*archive.FS, string, error) {
+	arch, file, ok := archive.Split(name)
+	if !ok {
+		return nil, "", errors.New("not in an archive")
+	}
+	l := q.Value(lastArchiveKey{}, func() any { return &lastArchive{} }).(*lastArchive)
+	if l.FS == nil || l.Name != arch {
+		l.Close()
+		l.FS = nil
+		a, err := archive.Open(arch)
+		if err != nil {
+			return nil, "", err
+		}
+		l.FS = a
+	}
+	return l.FS, file, nil
+}
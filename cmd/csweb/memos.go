@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/touchmarine/sandd/memos"
+)
+
+func init() {
+	registerSource(&memoSource{})
+}
+
+// The Memos database memosDB, relative to the home directory, is served by
+// Memos at memosURL (see compose.yaml).
+const (
+	memosDB  = ".sandd/memos/memos_prod.db"
+	memosURL = "https://memos.sd.test/m/"
+)
+
+// memosFile returns the name of the Memos database to search.
+func memosFile() string {
+	if *memosFlag != "" {
+		return *memosFlag
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, memosDB)
+}
+
+// memoName returns the name of the memo m in the database db in search
+// results, as if the database were a directory of Markdown files.
+func memoName(db string, m memos.Memo) string {
+	return db + "/" + m.UID + ".md"
+}
+
+// memoSource provides the memos. Memos are not indexed, so all of them are
+// listed if they match. They are loaded again when the database changes.
+type memoSource struct {
+	mu     sync.Mutex
+	db     string
+	stamp  string // modification times of the database and its log
+	names  []string
+	byName map[string]memos.Memo
+}
+
+// load returns the names of the memos, in the order they were created, and
+// the memos by name.
+func (s *memoSource) load() ([]string, map[string]memos.Memo, error) {
+	db := memosFile()
+	var stamp strings.Builder
+	for _, name := range []string{db, db + "-wal"} {
+		if fi, err := os.Stat(name); err == nil {
+			stamp.WriteString(fi.ModTime().String())
+			fmt.Fprint(&stamp, fi.Size())
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.db == db && s.stamp == stamp.String() {
+		return s.names, s.byName, nil
+	}
+	ms, err := memos.Load(db)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.db, s.stamp = db, stamp.String()
+	s.names, s.byName = nil, make(map[string]memos.Memo)
+	for _, m := range ms {
+		name := memoName(db, m)
+		s.names = append(s.names, name)
+		s.byName[name] = m
+	}
+	return s.names, s.byName, nil
+}
+
+// memo returns the named memo.
+func (s *memoSource) memo(name string) (memos.Memo, error) {
+	_, byName, err := s.load()
+	if err != nil {
+		return memos.Memo{}, err
+	}
+	m, ok := byName[name]
+	if !ok {
+		return memos.Memo{}, fs.ErrNotExist
+	}
+	return m, nil
+}
+
+func (s *memoSource) List(q *Query) ([]string, error) {
+	names, byName, err := s.load()
+	if err != nil {
+		if *memosFlag == "" && errors.Is(err, fs.ErrNotExist) {
+			// Memos is not used
+			return nil, nil
+		}
+		return nil, err
+	}
+	var matches []string
+	for _, name := range names {
+		if q.Expr.match([]byte(byName[name].Content)) {
+			matches = append(matches, name)
+		}
+	}
+	return matches, nil
+}
+
+func (s *memoSource) Open(q *Query, name string) (*Document, error) {
+	m, err := s.memo(name)
+	if err != nil {
+		return nil, err
+	}
+	return &Document{
+		ReadCloser: io.NopCloser(strings.NewReader(m.Content)),
+		Line: func(n int) Line {
+			l := fileLine(name, n)
+			l.Anchor = "" // memos have no line anchors
+			return l
+		},
+	}, nil
+}
+
+func (s *memoSource) Link(name string) string {
+	m, err := s.memo(name)
+	if err != nil {
+		return ""
+	}
+	return memosURL + url.PathEscape(m.UID)
+}
+
+func (s *memoSource) ModTime(q *Query, name string) (time.Time, error) {
+	m, err := s.memo(name)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return m.Updated, nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/touchmarine/sandd/highlight"
+	"github.com/touchmarine/sandd/notebook"
+)
+
+func init() {
+	registerFormat(notebook.IsNotebook, decodeNotebook)
+}
+
+// decodeNotebook returns the document of the cells of a notebook, and
+// their text outputs if the query searches them.
+func decodeNotebook(q *Query, data []byte) (*Document, error) {
+	nb, err := notebook.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	text, lines := nb.Text(q.Outputs)
+	lang := highlight.Lookup(nb.Language)
+	return &Document{
+		ReadCloser: io.NopCloser(bytes.NewReader(text)),
+		Line: func(n int) Line {
+			l := lines[n-1]
+			var hl *highlight.Language
+			if nb.Cells[l.Cell-1].Type == "code" && !l.Output {
+				hl = lang
+			}
+			return Line{Label: l.String(), Anchor: l.Anchor(), Lang: hl}
+		},
+	}, nil
+}
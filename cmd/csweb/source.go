@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/google/codesearch/index"
+	"github.com/touchmarine/sandd/archive"
+	"github.com/touchmarine/sandd/charset"
+	"github.com/touchmarine/sandd/highlight"
+)
+
+// A Source provides documents to search: files, archive entries, memos and
+// so on. Sources register themselves with registerSource.
+type Source interface {
+	// List returns the names of the documents that may match the query.
+	List(q *Query) ([]string, error)
+	// Open opens the named document listed by the source.
+	Open(q *Query, name string) (*Document, error)
+	// Link returns the URL showing the named document.
+	Link(name string) string
+	// ModTime returns the time the named document was last modified.
+	ModTime(q *Query, name string) (time.Time, error)
+}
+
+// sources are the registered sources.
+var sources []Source
+
+// registerSource adds s to the sources searched.
+func registerSource(s Source) {
+	sources = append(sources, s)
+}
+
+// Query is a search of the sources. It holds the state shared by the
+// sources during the search, such as open archives.
+type Query struct {
+	Expr    *queryExpr
+	Outputs bool // search notebook cell outputs
+
+	ix     *index.Index
+	post   []int
+	posted bool
+	values map[any]any
+}
+
+// Index returns the index, opening it on first use.
+func (q *Query) Index() *index.Index {
+	if q.ix == nil {
+		q.ix = index.Open(index.File())
+		q.ix.Verbose = *verboseFlag
+	}
+	return q.ix
+}
+
+// Posting returns the ids of the indexed files that may match the query.
+func (q *Query) Posting() []int {
+	if !q.posted {
+		q.post = q.Index().PostingQuery(q.Expr.indexQuery())
+		q.posted = true
+	}
+	return q.post
+}
+
+// Value returns the value of key for this query, calling init to create it
+// on first use. Values that are io.Closers are closed by Close.
+func (q *Query) Value(key any, init func() any) any {
+	if v, ok := q.values[key]; ok {
+		return v
+	}
+	if q.values == nil {
+		q.values = make(map[any]any)
+	}
+	v := init()
+	q.values[key] = v
+	return v
+}
+
+// Close releases the resources held by the query.
+func (q *Query) Close() {
+	for _, v := range q.values {
+		if c, ok := v.(io.Closer); ok {
+			c.Close()
+		}
+	}
+}
+
+// A Document is an open document.
+type Document struct {
+	io.ReadCloser
+	// Line, if not nil, describes line n (1-based) of the text of a
+	// document whose lines are not those of a plain file, like the lines
+	// of the cells of a notebook.
+	Line func(n int) Line
+}
+
+// Line describes a line of a document in search results.
+type Line struct {
+	Label  string              // shown to the user, like "#12" or "cell 3, line 2"
+	Anchor string              // URL fragment of the line, or "" if none
+	Lang   *highlight.Language // for highlighting
+}
+
+// fileLine describes line n of the named plain file.
+func fileLine(name string, n int) Line {
+	return Line{
+		Label:  fmt.Sprintf("#%d", n),
+		Anchor: fmt.Sprintf("L%d", n),
+		Lang:   highlight.Detect(baseName(name)),
+	}
+}
+
+// A format converts files of some type into the text to search, like the
+// cells of notebooks. Formats register themselves with registerFormat.
+type format struct {
+	match  func(name string) bool
+	decode func(q *Query, data []byte) (*Document, error)
+}
+
+var formats []format
+
+// registerFormat adds the format of the files matching match.
+func registerFormat(match func(name string) bool, decode func(q *Query, data []byte) (*Document, error)) {
+	formats = append(formats, format{match, decode})
+}
+
+// openFile returns the document of the named file with contents r,
+// decompressed if the file is compressed, decoded to UTF-8 if it is text and
+// decoded by its format, if any. Files that fail to decode by their format
+// are searched as text.
+func openFile(q *Query, name string, r io.ReadCloser) (*Document, error) {
+	if archive.IsCompressed(name) {
+		dr, err := archive.Decompress(name, r)
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+		r = readCloser{dr, r}
+		name = archive.Decompressed(name)
+	}
+	text, _ := charset.NewReader(r)
+	r = readCloser{text, r}
+	for _, f := range formats {
+		if !f.match(name) {
+			continue
+		}
+		data, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return nil, err
+		}
+		if doc, err := f.decode(q, data); err == nil {
+			return doc, nil
+		}
+		return &Document{ReadCloser: io.NopCloser(bytes.NewReader(data))}, nil
+	}
+	return &Document{ReadCloser: r}, nil
+}
+
+// readCloser reads from a Reader and closes a Closer, like a decompressor
+// and the file it reads.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -8,32 +8,50 @@
 package main
 
 import (
-	"archive/zip"
 	"bytes"
 	"cmp"
+	"context"
 	"embed"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
-	"html"
+	"html/template"
 	"io"
 	"io/fs"
 	"log"
+	"mime"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
+	goregexp "regexp"
+	"runtime"
 	"slices"
 	"strings"
+	"sync"
 	"time"
 	"unicode/utf8"
 
 	"github.com/google/codesearch/index"
 	"github.com/google/codesearch/regexp"
+	"github.com/touchmarine/sandd/archive"
+	"github.com/touchmarine/sandd/charset"
 	"github.com/touchmarine/sandd/codesearchpatch"
 	"github.com/touchmarine/sandd/dirtree"
+	"github.com/touchmarine/sandd/highlight"
+	"github.com/touchmarine/sandd/markdown"
+	"github.com/touchmarine/sandd/notebook"
 )
 
-var verboseFlag = flag.Bool("verbose", false, "print extra information")
+var (
+	verboseFlag   = flag.Bool("verbose", false, "print extra information")
+	allowFlag     = flag.String("allow", "", "list of directories /show/ may serve, separated by the OS path list separator (default: the indexed roots)")
+	memosFlag     = flag.String("memos", "", "Memos database to search (default: ~/"+memosDB+")")
+	fileLimitFlag = flag.Int("filelimit", 3, "maximum number of matches shown per file (0: no limit)")
+)
 
 func main() {
 	flag.Parse()
@@ -47,223 +65,347 @@ func main() {
 //go:embed _static
 var static embed.FS
 
+//go:embed _templates
+var templates embed.FS
+
+// pages maps each page to its template, parsed together with the shared
+// layout.
+var pages = parsePages("home", "dir", "file", "markdown", "notebook", "binary", "forbidden")
+
+func parsePages(names ...string) map[string]*template.Template {
+	m := make(map[string]*template.Template, len(names))
+	for _, name := range names {
+		m[name] = template.Must(template.ParseFS(templates, "_templates/layout.html", "_templates/"+name+".html"))
+	}
+	return m
+}
+
+// render executes the page template with data and writes it with the given
+// status code.
+func render(w http.ResponseWriter, status int, page string, data any) {
+	var buf bytes.Buffer
+	if err := pages[page].ExecuteTemplate(&buf, "layout", data); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	buf.WriteTo(w)
+}
+
+type homeData struct {
+	Query string
+	File  string
+	queryOptions
+	Outputs bool // search notebook cell outputs
+	Result  *searchResult
+	Err     error
+}
+
 func home(w http.ResponseWriter, r *http.Request) {
-	qarg := r.FormValue("q")
-	farg := r.FormValue("f")
-	isCaseSensitive := r.FormValue("case-sensitive") != ""
-	isRegex := r.FormValue("regex") != ""
-
-	replacements := []string{
-		"QUERY", html.EscapeString(qarg),
-		"FILE", html.EscapeString(farg),
-	}
-	if isCaseSensitive {
-		replacements = append(replacements, "CASE-SENSITIVE", "checked")
-	}
-	if isRegex {
-		replacements = append(replacements, "REGEX", "checked")
-	}
-	replaced := strings.NewReplacer(replacements...).Replace(homePage)
-	w.Write([]byte(replaced))
-	searchPartial(w, qarg, farg, !isRegex, !isCaseSensitive)
-
-	w.Write([]byte(
-		`
-    </main>
-</div>
-
-<script src="https://cdnjs.cloudflare.com/ajax/libs/highlight.js/11.10.0/highlight.min.js"></script>
-<script>hljs.highlightAll();</script>
-<script>
-matchesNoTop = document.getElementById('matches-no-top')
-matchesNoBottom = document.getElementById('matches-no-bottom')
-matchesNoTop.textContent = matchesNoBottom.textContent
-document.querySelectorAll('[data-cur-dir]').forEach((btn) => {
-    btn.addEventListener('click', () => {
-        const input = document.getElementById('file')
-        input.value = btn.dataset.curDir
-    })
-})
-document.querySelectorAll('[data-ext-pattern]').forEach((btn) => {
-    btn.addEventListener('click', () => {
-        const input = document.getElementById('file')
-        input.value = btn.dataset.extPattern
-    })
-})
-</script>
-</body>
-</html>
-`))
-}
-
-const homePage = `
-<!DOCTYPE html>
-<html>
-<head>
-<link rel="stylesheet" href="https://cdnjs.cloudflare.com/ajax/libs/highlight.js/11.10.0/styles/github.min.css">
-<style>
-header {
-    margin-bottom: 32px;
-}
-.match {
-    margin-bottom: 32px;
-}
-</style>
-</head>
-
-<body>
-<header>
-    <form style="display: flex; column-gap: 32px; text-wrap: nowrap;">
-        <label for="query">Search:</label>
-        <input type="search" id="query" name="q" value="QUERY" placeholder="Search (regex)" style="width: 100%;">
-
-        <label for="file">Path:</label>
-        <input type="search" id="file" name="f" value="FILE" placeholder="Filter Files (regex)" style="width: 100%;">
-
-        <input type="checkbox" id="case-sensitive" name="case-sensitive" CASE-SENSITIVE>
-        <label for="case-sensitive">Case-Sensitive</label>
-
-        <input type="checkbox" id="regex" name="regex" REGEX>
-        <label for="regex">Regular Expression</label>
-
-        <button>Search</button>
-    </form>
-</header>
-<div class="container">
-    <!--
-    <aside>
-        <form>
-            <fieldset>
-                <legend>Paths</legend>
-
-                <input type="checkbox" id="dbt" name="paths" value="/dbt">
-                <label for="dbt">/dbt</label>
-            </fieldset>
-            <fieldset>
-                <legend>Languages</legend>
-
-                <input type="checkbox" id="go" name="languages" value="go">
-                <label for="go">Go</label>
-            </fieldset>
-            <button>Update</button>
-        </form>
-    </aside>
-    -->
-    <main>
-    <p id="matches-no-top" style="margin-bottom: 32px;"> matches in s</p>
-`
-
-func searchPartial(w io.Writer, qarg, farg string, literal, caseInsensitive bool) {
-	var b bytes.Buffer
-	prevName := ""
-	g := codesearchpatch.Grep{
-		N:      true,
-		Limit:  10,
-		Stdout: w,
-		Stderr: w,
-		OnMatch: func(buf []byte, name string, lineno, lineStart, lineEnd int) {
-			if name == prevName {
-				// the same file
-				b.Reset() // clear closing tag
-			} else {
-				// new file
-				fmt.Fprint(&b, `<div class="match">`)
-				fmt.Fprintf(&b, "<p>%s (<a href=\"/show/%s\">show</a>)</p>\n", html.EscapeString(name), html.EscapeString(strings.ReplaceAll(name, "#", ">")))
-			}
+	d := homeData{
+		Query:        r.FormValue("q"),
+		File:         r.FormValue("f"),
+		queryOptions: formQueryOptions(r),
+		Outputs:      r.FormValue("outputs") != "",
+	}
+	d.Result, d.Err = search(r.Context(), d.Query, d.File, d.queryOptions, d.Outputs)
+	render(w, http.StatusOK, "home", d)
+}
 
-			fmt.Fprintf(&b, "<small style=\"float: right;\"><a href=\"/show/%s#L%d\">#%d</a></small>\n", html.EscapeString(strings.ReplaceAll(name, "#", ">")), lineno, lineno)
-			fmt.Fprint(&b, "<pre><code>")
-			before, match, after := codesearchpatch.LineContext(1, 1, buf, lineStart, lineEnd)
-			for _, line := range before {
-				fmt.Fprintf(&b, "%s\n", line)
-			}
-			fmt.Fprintf(&b, "%s\n", match)
-			for _, line := range after {
-				fmt.Fprintf(&b, "%s\n", line)
-			}
-			fmt.Fprint(&b, "</code></pre>\n")
+type searchResult struct {
+	Matches  int
+	Duration time.Duration
+	Limited  bool        // stopped because of the match limit
+	Verbose  []string    // extra information with -verbose
+	Dirs     []dirFacet  // directories to narrow the search to
+	Exts     []extFacet  // extensions to narrow the search to
+	Files    []fileMatch // matches grouped by file
+	Errors   string      // errors reading files
+}
 
-			b.WriteTo(w) // flush
+type dirFacet struct {
+	Abs string
+	Rel string
+}
 
-			// Buffer the closing tag so we have all match's html here. The buffer is:
-			// - reset if the next match is in the same file,
-			// - flushed if the next match is not in the same file or if end of matches.
-			fmt.Fprint(&b, "</div>\n")
+type extFacet struct {
+	Ext     string
+	Pattern string // file name pattern
+}
 
-			prevName = name
-		},
+type fileMatch struct {
+	Name     string
+	URL      string
+	Modified time.Time // zero if unknown
+	Matches  []lineMatch
+	More     int    // matches not shown because of -filelimit
+	MoreURL  string // of the file view showing them
+}
+
+type lineMatch struct {
+	Lineno    int
+	Label     string // position shown to the user, like "#12" or "cell 3, line 2"
+	URL       string
+	Lines     []template.HTML // highlighted matched line with context
+	Truncated bool            // whether long lines were truncated
+	Term      string          // matched, of a query of several terms
+}
+
+// queryOptions are the options of a search query, the inputs of the form
+// of the home page.
+type queryOptions struct {
+	Case      string // caseSmart, caseSensitive or caseInsensitive
+	Regex     bool   // query is a regexp, not literal text
+	WholeWord bool   // query matches whole words only
+	Space     bool   // whitespace runs in the query match any, across lines
+}
+
+// The case modes of queries, also set by a case:<mode> word at the
+// beginning or end of the query (see queryCase).
+const (
+	caseSmart       = "smart" // insensitive unless the query has upper case letters
+	caseSensitive   = "yes"
+	caseInsensitive = "no"
+)
+
+// formQueryOptions returns the query options in the form values of r.
+func formQueryOptions(r *http.Request) queryOptions {
+	o := queryOptions{
+		Case:      caseSmart,
+		Regex:     r.FormValue("regex") != "",
+		WholeWord: r.FormValue("word") != "",
+		Space:     r.FormValue("space") != "",
+	}
+	switch c := r.FormValue("case"); {
+	case c == caseSensitive || c == caseInsensitive:
+		o.Case = c
+	case r.FormValue("case-sensitive") != "":
+		// the checkbox of earlier versions
+		o.Case = caseSensitive
+	}
+	return o
+}
+
+// values returns the form values of the options.
+func (o queryOptions) values() url.Values {
+	v := url.Values{}
+	if o.Case != caseSmart {
+		v.Set("case", o.Case)
+	}
+	if o.Regex {
+		v.Set("regex", "on")
+	}
+	if o.WholeWord {
+		v.Set("word", "on")
 	}
+	if o.Space {
+		v.Set("space", "on")
+	}
+	return v
+}
 
-	afterReader := func() {
-		// flush any unread buffer (should be closing div tag)
-		b.WriteTo(w)
+// queryCase returns the query qarg without a case:<mode> word at its
+// beginning or end, and the case mode, mode if there is no such word.
+func queryCase(qarg, mode string) (string, string) {
+	for _, m := range []string{caseSmart, caseSensitive, caseInsensitive} {
+		w := "case:" + m
+		if q, ok := strings.CutPrefix(qarg, w+" "); ok {
+			return q, m
+		}
+		if q, ok := strings.CutSuffix(qarg, " "+w); ok {
+			return q, m
+		}
 	}
+	return qarg, mode
+}
 
-	pat := qarg
-	if literal {
-		pat = backslashEscapeAllPunctuation(pat)
+// termRegexp returns the regexp of the query term pat.
+func termRegexp(pat string, o queryOptions) (*regexp.Regexp, error) {
+	caseInsensitive := o.Case == caseInsensitive ||
+		o.Case == caseSmart && !codesearchpatch.HasUpper(pat, o.Regex)
+	if !o.Regex {
+		// The syntax of codesearch's regexps is that of package regexp.
+		pat = goregexp.QuoteMeta(pat)
+	}
+	if o.Space {
+		pat = codesearchpatch.SpaceInsensitive(pat)
+	}
+	if o.WholeWord {
+		pat = codesearchpatch.WholeWord(pat)
 	}
 	pat = "(?m)" + pat // multiline: ^ and $ match begin/end line in addition to begin/end text
 	if caseInsensitive {
 		pat = "(?i)" + pat // case-insensitive
 	}
-	re, err := regexp.Compile(pat)
+	return regexp.Compile(pat)
+}
+
+// viewQuery returns the URL query of the file view showing the lines
+// matching the search query qarg (see writeFile).
+func viewQuery(qarg string, o queryOptions) string {
+	v := o.values()
+	v.Set("q", qarg)
+	return v.Encode()
+}
+
+// A document is a document listed by a source.
+type document struct {
+	src  Source
+	name string
+}
+
+// runKey returns the key of the runs of documents searched together: the
+// archive containing the named document, or its name.
+func runKey(name string) string {
+	if arch, _, ok := archive.Split(name); ok {
+		return arch
+	}
+	return name
+}
+
+// newFileMatch returns the search result of the document d, open as doc,
+// with the matches of the terms of the query rs. moreQuery is the query of
+// the view of the matches not shown (see viewQuery).
+func newFileMatch(q *Query, d document, doc *Document, rs []termResult, moreQuery string) fileMatch {
+	link := d.src.Link(d.name)
+	f := fileMatch{Name: d.name, URL: link}
+	f.Modified, _ = d.src.ModTime(q, d.name)
+	for _, r := range rs {
+		for i := range r.Matches {
+			m := newLineMatch(d.name, link, doc, &r.Matches[i])
+			m.Term = r.term
+			f.Matches = append(f.Matches, m)
+		}
+		f.More += r.Skipped
+	}
+	slices.SortStableFunc(f.Matches, func(a, b lineMatch) int {
+		return cmp.Compare(a.Lineno, b.Lineno)
+	})
+	f.MoreURL = link
+	if link == showURL(d.name) {
+		f.MoreURL += "?" + moreQuery
+	}
+	return f
+}
+
+// newLineMatch returns the snippet of the match m in the named document,
+// shown at link.
+func newLineMatch(name, link string, doc *Document, m *codesearchpatch.Match) lineMatch {
+	before, match, after, start := m.Context()
+	snippet := slices.Concat(before, match, after)
+	cuts := make([][2]bool, len(snippet)) // beginning, end
+	truncated := false
+	for i := range snippet {
+		at := -1
+		if i == len(before) {
+			at = start
+		}
+		snippet[i], cuts[i][0], cuts[i][1] = truncateLine(snippet[i], at)
+		truncated = truncated || cuts[i][0] || cuts[i][1]
+	}
+	l := fileLine(name, m.Lineno)
+	if doc.Line != nil {
+		l = doc.Line(m.Lineno)
+	} else if m.EndLineno > m.Lineno {
+		l.Label += fmt.Sprintf("-%d", m.EndLineno)
+	}
+	url := link
+	if l.Anchor != "" {
+		url += "#" + l.Anchor
+	}
+	lines := highlight.Lines(l.Lang, bytes.Join(snippet, nl))
+	for len(lines) < len(snippet) {
+		// trailing empty lines
+		lines = append(lines, "")
+	}
+	for i, c := range cuts {
+		if c[0] {
+			lines[i] = ellipsis + lines[i]
+		}
+		if c[1] {
+			lines[i] += ellipsis
+		}
+	}
+	return lineMatch{
+		Lineno:    m.Lineno,
+		Label:     l.Label,
+		URL:       url,
+		Lines:     lines,
+		Truncated: truncated,
+	}
+}
+
+// maxMatches is the maximum number of matches a search shows.
+const maxMatches = 10
+
+// search searches the sources. It stops when ctx is canceled, like when the
+// client goes away.
+func search(ctx context.Context, qarg, farg string, o queryOptions, outputs bool) (*searchResult, error) {
+	res := &searchResult{}
+	var stderr bytes.Buffer
+	q := &Query{Outputs: outputs}
+	defer q.Close()
+
+	x, err := parseQuery(qarg, o)
 	if err != nil {
-		fmt.Fprintf(w, "Bad query: %v\n", err)
-		return
+		return nil, err
 	}
-	g.Regexp = re
+	q.Expr = x
 	var fre *regexp.Regexp
 	if farg != "" {
 		fre, err = regexp.Compile(farg)
 		if err != nil {
-			fmt.Fprintf(w, "Bad -f flag: %v\n", err)
-			return
+			return nil, fmt.Errorf("bad path: %v", err)
 		}
 	}
-	q := index.RegexpQuery(re.Syntax)
 	if *verboseFlag {
-		log.Printf("query: %s\n", q)
+		log.Printf("query: %s\n", x.indexQuery())
 	}
 
 	start := time.Now()
-	ix := index.Open(index.File())
-	ix.Verbose = *verboseFlag
-	post := ix.PostingQuery(q)
+	var docs []document
+	for _, s := range sources {
+		names, err := s.List(q)
+		if err != nil {
+			fmt.Fprintf(&stderr, "%v\n", err)
+			continue
+		}
+		for _, name := range names {
+			docs = append(docs, document{s, name})
+		}
+	}
 	if *verboseFlag {
-		fmt.Fprintf(w, "post query identified %d possible files\n", len(post))
+		res.Verbose = append(res.Verbose, fmt.Sprintf("sources identified %d possible documents", len(docs)))
 	}
 
 	exts := map[string]int{}
-	for _, fileid := range post {
-		name := ix.Name(fileid).String()
-		ext := filepath.Ext(name)
+	for _, d := range docs {
+		ext := filepath.Ext(d.name)
 		// trigram match count, not actual matched files count
 		exts[ext]++
 	}
 
 	if fre != nil {
-		fnames := make([]int, 0, len(post))
+		fdocs := make([]document, 0, len(docs))
 
-		for _, fileid := range post {
-			name := ix.Name(fileid)
-			if fre.MatchString(name.String(), true, true) < 0 {
+		for _, d := range docs {
+			if fre.MatchString(d.name, true, true) < 0 {
 				continue
 			}
-			fnames = append(fnames, fileid)
+			fdocs = append(fdocs, d)
 		}
 
 		if *verboseFlag {
-			fmt.Fprintf(w, "filename regexp matched %d files\n", len(fnames))
+			res.Verbose = append(res.Verbose, fmt.Sprintf("filename regexp matched %d documents", len(fdocs)))
 		}
-		post = fnames
+		docs = fdocs
 	}
 
 	// suggest directories to search
 	names := map[string]interface{}{}
-	for _, fileid := range post { // already filtered!
-		name := ix.Name(fileid).String()
-		names[name] = nil
+	for _, d := range docs { // already filtered!
+		names[d.name] = nil
 	}
 
 	t := &dirtree.Node{}
@@ -301,9 +443,8 @@ func searchPartial(w io.Writer, qarg, farg string, literal, caseInsensitive bool
 		base := filepath.Join(parentSegments...)
 		rel := d.Value
 		abs := filepath.Join(base, rel)
-		fmt.Fprintf(w, "<button data-cur-dir=\"%s\">%s</button>\n", abs, rel)
+		res.Dirs = append(res.Dirs, dirFacet{Abs: abs, Rel: rel})
 	}
-	fmt.Fprintf(w, "<hr>\n")
 
 	// sort extensions by count desc, ext asc
 	type extInfo struct {
@@ -324,204 +465,840 @@ func searchPartial(w io.Writer, qarg, farg string, literal, caseInsensitive bool
 	for _, e := range exts2 {
 		// Don't show count as it's misleading since it's not the actual count
 		// (this serves as a plain suggestion).
-		fmt.Fprintf(w, "<button data-ext-pattern=\".*\\%s$\">%s</button>\n", e.ext, e.ext)
+		res.Exts = append(res.Exts, extFacet{Ext: e.ext, Pattern: `.*\` + e.ext + `$`})
 	}
 
+	// The documents are searched in parallel, in runs of documents in the
+	// same archive, which is opened once for a run (see openArchive). The
+	// matches are cut off at maxMatches in the order of the documents, not
+	// in the order their searches end, so a search shows the same matches
+	// each time. The searches stop when the documents searched before them
+	// in that order have more.
+	fileLimit := *fileLimitFlag
+	if fileLimit <= 0 || fileLimit > maxMatches {
+		fileLimit = maxMatches
+	}
+	engines := make([]*codesearchpatch.Engine, len(x.terms))
+	for i, t := range x.terms {
+		engines[i] = codesearchpatch.NewEngine(t.re, codesearchpatch.EngineConfig{
+			PreContext:  1,
+			PostContext: 1,
+			FileLimit:   fileLimit,
+			Near:        t.near,
+			Multiline:   o.Space,
+		})
+	}
+	var runs [][]int // of indexes in docs
+	for i, d := range docs {
+		if i == 0 || runKey(d.name) != runKey(docs[i-1].name) {
+			runs = append(runs, nil)
+		}
+		runs[len(runs)-1] = append(runs[len(runs)-1], i)
+	}
+	sctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type docResult struct {
+		file *fileMatch // if it matches
+		err  string
+		done bool // searched to the end
+	}
 	var (
-		zipFile   string
-		zipReader *zip.ReadCloser
-		zipMap    map[string]*zip.File
+		mu       sync.Mutex
+		results  = make([]docResult, len(docs))
+		searched = 0 // documents searched to the end, in order
+		found    = 0 // matches in them, not counting those over fileLimit
 	)
-
-	for _, fileid := range post {
-		if g.Limited {
-			break
+	finish := func(i int, r docResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		r.done = true
+		results[i] = r
+		for ; searched < len(docs) && results[searched].done; searched++ {
+			if f := results[searched].file; f != nil {
+				found += len(f.Matches)
+			}
 		}
-		name := ix.Name(fileid).String()
-		file, err := os.Open(name)
-		if err != nil {
-			if i := strings.Index(name, ".zip\x01"); i >= 0 {
-				zfile, zname := name[:i+4], name[i+5:]
-				if zfile != zipFile {
-					if zipReader != nil {
-						zipReader.Close()
-						zipMap = nil
+		if found > maxMatches {
+			cancel()
+		}
+	}
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range min(runtime.GOMAXPROCS(0), len(runs)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Queries are not safe for concurrent use.
+			q := &Query{Expr: x, Outputs: outputs}
+			defer q.Close()
+			for run := range next {
+				for _, i := range runs[run] {
+					if sctx.Err() != nil {
+						break
 					}
-					zipFile = zfile
-					zipReader, err = zip.OpenReader(zfile)
+					d := docs[i]
+					doc, err := d.src.Open(q, d.name)
 					if err != nil {
-						zipReader = nil
+						// gone since listed or indexed
+						finish(i, docResult{})
+						continue
 					}
-					if zipReader != nil {
-						zipMap = make(map[string]*zip.File)
-						for _, file := range zipReader.File {
-							zipMap[file.Name] = file
-						}
+					rs, err := searchExpr(sctx, x, engines, doc, d.name)
+					doc.Close()
+					if sctx.Err() != nil {
+						// not searched to the end
+						break
+					}
+					var r docResult
+					if slices.ContainsFunc(rs, func(r termResult) bool { return len(r.Matches) > 0 }) {
+						f := newFileMatch(q, d, doc, rs, viewQuery(qarg, o))
+						r.file = &f
 					}
-				}
-				file := zipMap[zname]
-				if file != nil {
-					r, err := file.Open()
 					if err != nil {
-						continue
+						r.err = fmt.Sprintf("%s: %v\n", d.name, err)
 					}
-					g.Reader(r, name)
-					afterReader()
-					r.Close()
-					continue
+					finish(i, r)
 				}
 			}
+		}()
+	}
+feed:
+	for i := range runs {
+		select {
+		case next <- i:
+		case <-sctx.Done():
+			break feed
+		}
+	}
+	close(next)
+	wg.Wait()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	for _, r := range results[:searched] {
+		stderr.WriteString(r.err)
+		f := r.file
+		if f == nil {
 			continue
 		}
-		g.Reader(file, name)
-		afterReader()
-		file.Close()
+		if n := maxMatches - res.Matches; len(f.Matches) > n {
+			res.Limited = true
+			if n == 0 {
+				break
+			}
+			f.More += len(f.Matches) - n
+			f.Matches = f.Matches[:n]
+		}
+		res.Matches += len(f.Matches)
+		res.Files = append(res.Files, *f)
 	}
+	res.Limited = res.Limited || searched < len(docs)
 
-	fmt.Fprintf(w, "\n<p id='matches-no-bottom'>%d matches in %.3fs</p>\n", g.Matches, time.Since(start).Seconds())
-	if g.Limited {
-		fmt.Fprintf(w, "<p>more matches not shown due to match limit</p>\n")
-	}
+	res.Duration = time.Since(start)
+	res.Errors = stderr.String()
+	return res, nil
 }
 
 func show(w http.ResponseWriter, r *http.Request) {
-	file := strings.TrimPrefix(r.URL.Path, "/show")
+	// links made from indexes with the legacy names of files in zip files
+	file := archive.FromLegacy(strings.TrimPrefix(r.URL.Path, "/show"))
 	if strings.HasPrefix(file, "/") && filepath.IsAbs(file[1:]) {
 		// Turn /c:/foo into c:/foo on Windows.
 		file = file[1:]
 	}
-	// TODO maybe trim file by ix.roots
-	// TODO zips
-	info, err := os.Stat(file)
+	outer := archive.Outer(file)
+	real, err := resolvePath(outer)
+	if err == errForbidden {
+		forbidden(w, file)
+		return
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		http.Error(w, err.Error(), 404)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if outer != file {
+		// in an archive
+		showArchive(w, r, file, real+file[len(outer):])
+		return
+	}
+	info, err := os.Stat(real)
 	if err != nil {
 		// TODO
 		http.Error(w, err.Error(), 500)
 		return
 	}
 	if info.IsDir() {
-		dirs, err := os.ReadDir(file)
+		dirs, err := os.ReadDir(real)
 		if err != nil {
 			http.Error(w, err.Error(), 500)
 			return
 		}
-		w.Write(serveDir(file, dirs))
+		serveDir(w, file, dirs)
+		return
+	}
+	if archive.Is(file) {
+		// list the archive like a directory
+		showArchive(w, r, file, real)
 		return
 	}
 
-	data, err := os.ReadFile(file)
+	data, err := os.ReadFile(real)
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
 	}
-	w.Write(serveFile(file, data))
+	writeFile(w, r, file, data)
 }
 
-func printHeader(buf *bytes.Buffer, file string) {
-	e := html.EscapeString
-	buf.WriteString("<!DOCTYPE html>\n<head>\n")
-	buf.WriteString("<link rel=\"stylesheet\" href=\"/_static/viewer.css\">\n")
-	buf.WriteString("<script src=\"/_static/viewer.js\"></script>\n")
-	fmt.Fprintf(buf, `<title>%s - code search</title>`, e(file))
-	buf.WriteString("\n</head><body onload=\"highlight()\"><pre>\n")
+var errForbidden = errors.New("path is not under an allowed root")
+
+// resolvePath returns the canonical form of name (absolute, with symlinks and
+// .. elements resolved) if both name and its canonical form are under one of
+// the allowed roots; otherwise it returns errForbidden.
+//
+// The name is checked before it is resolved so that the existence of files
+// outside the roots is not revealed.
+func resolvePath(name string) (string, error) {
+	roots := allowedRoots()
+	name = filepath.Clean(name)
+	if !underRoot(name, roots) {
+		return "", errForbidden
+	}
+	real, err := filepath.EvalSymlinks(name)
+	if err != nil {
+		return "", err
+	}
+	real, err = filepath.Abs(real)
+	if err != nil {
+		return "", err
+	}
+	realRoots := make([]string, 0, len(roots))
+	for _, root := range roots {
+		r, err := filepath.EvalSymlinks(root)
+		if err != nil {
+			continue
+		}
+		if r, err = filepath.Abs(r); err == nil {
+			realRoots = append(realRoots, r)
+		}
+	}
+	if !underRoot(real, realRoots) {
+		return "", errForbidden
+	}
+	return real, nil
+}
+
+// allowedRoots returns the directories /show/ may serve: the -allow list if
+// given, otherwise the roots of the index.
+func allowedRoots() []string {
+	var roots []string
+	if *allowFlag != "" {
+		for _, root := range filepath.SplitList(*allowFlag) {
+			if root != "" {
+				roots = append(roots, filepath.Clean(root))
+			}
+		}
+		return roots
+	}
+	ix := index.Open(index.File())
+	for root := range ix.Roots().All() {
+		roots = append(roots, filepath.Clean(root.String()))
+	}
+	return roots
+}
+
+// underRoot reports whether name is one of roots or is inside one of them.
+func underRoot(name string, roots []string) bool {
+	p := index.MakePath(name)
+	for _, root := range roots {
+		if p.HasPathPrefix(index.MakePath(root)) {
+			return true
+		}
+	}
+	return false
+}
+
+func forbidden(w http.ResponseWriter, file string) {
+	render(w, http.StatusForbidden, "forbidden", newViewer(file))
+}
+
+// showArchive serves the file or directory name in an archive, or the
+// root of the archive name. Archives in archives are listed like
+// directories too.
+func showArchive(w http.ResponseWriter, r *http.Request, file, name string) {
+	arch, p := name, "."
+	if a, f, ok := archive.Split(name); ok {
+		arch, p = a, strings.Trim(f, "/")
+		if p == "" {
+			p = "."
+		}
+	}
+	a, err := archive.Open(arch)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer a.Close()
+
+	info, err := fs.Stat(a, p)
+	if errors.Is(err, fs.ErrNotExist) {
+		http.Error(w, err.Error(), 404)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if !info.IsDir() && archive.Is(p) {
+		nested, err := a.Sub(p)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		defer nested.Close()
+		a, p = nested, "."
+		info, err = fs.Stat(a, p)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+	}
+	if info.IsDir() {
+		dirs, err := fs.ReadDir(a, p)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		serveDir(w, file, dirs)
+		return
+	}
+
+	data, err := fs.ReadFile(a, p)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	writeFile(w, r, file, data)
+}
+
+// joinName joins the directory name dir and the element elem. Elements of
+// the root of an archive are joined with archive.Sep, as they are named in
+// the index.
+func joinName(dir, elem string) string {
+	if archive.Is(dir) {
+		return dir + archive.Sep + elem
+	}
+	return path.Join(dir, elem)
+}
+
+// showURL returns the /show/ URL of the file or directory name.
+func showURL(name string) string {
+	u := url.URL{Path: "/show" + name}
+	return u.EscapedPath()
+}
+
+// viewer is the data common to the /show/ pages.
+type viewer struct {
+	File     string
+	Crumbs   []link // path elements of File
+	Encoding string // of a text file, if not UTF-8
+}
+
+type link struct {
+	Name string
+	URL  string
+}
+
+func newViewer(file string) viewer {
+	v := viewer{File: file}
 	f := ""
-	for _, elem := range strings.Split(file, "/") {
-		f += "/" + elem
-		fmt.Fprintf(buf, `/<a href="/show%s">%s</a>`, e(f), e(elem))
+	for i, part := range archive.Parts(file) {
+		// the file on disk, then the paths in the archives
+		for j, elem := range strings.Split(part, "/") {
+			if i > 0 && j == 0 {
+				f += archive.Sep
+			} else {
+				f += "/"
+			}
+			f += elem
+			v.Crumbs = append(v.Crumbs, link{Name: elem, URL: showURL(f)})
+		}
 	}
-	fmt.Fprintf(buf, `</b> <small>(<a href="/">about</a>)</small>`)
-	fmt.Fprintf(buf, "\n\n")
+	return v
 }
 
-func serveDir(file string, dir []fs.DirEntry) []byte {
-	var buf bytes.Buffer
-	e := html.EscapeString
-	printHeader(&buf, file)
-	for _, d := range dir {
+// newTextViewer returns the viewer of the text file in the encoding enc.
+func newTextViewer(file string, enc *charset.Encoding) viewer {
+	v := newViewer(file)
+	if enc != charset.UTF8 {
+		v.Encoding = enc.Name
+	}
+	return v
+}
+
+func serveDir(w http.ResponseWriter, file string, dir []fs.DirEntry) {
+	d := struct {
+		viewer
+		Entries []link
+	}{viewer: newViewer(file)}
+	for _, e := range dir {
 		// Note: file is the full path including mod@vers.
-		file := path.Join(file, d.Name())
-		fmt.Fprintf(&buf, "<a href=\"/show%s\">%s</a>\n", e(file), e(path.Base(file)))
+		d.Entries = append(d.Entries, link{Name: e.Name(), URL: showURL(joinName(file, e.Name()))})
 	}
-	return buf.Bytes()
+	render(w, http.StatusOK, "dir", d)
 }
 
 var nl = []byte("\n")
 
-func serveFile(file string, data []byte) []byte {
-	if !isText(data) {
-		return data
+// writeFile writes the view of the file: numbered lines for text, an inline
+// preview for images and PDFs and a hex dump for other binary data. Text is
+// decoded to UTF-8 from its encoding (see charset.Detect). Markdown
+// documents are rendered unless the view form value is "source". Compressed
+// files are shown decompressed. If the q form value is set, only the lines
+// of text matching the search query are shown, as source, with the query
+// options of the form of the home page. If the raw form value is set,
+// it writes the data itself instead (see serveRaw).
+func writeFile(w http.ResponseWriter, r *http.Request, file string, data []byte) {
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if archive.IsCompressed(file) {
+		var err error
+		if data, err = decompress(file, data); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+	}
+	if r.FormValue("raw") != "" {
+		serveRaw(w, file, data)
+		return
 	}
+	var filter *lineFilter
+	if q := r.FormValue("q"); q != "" {
+		x, err := parseQuery(q, formQueryOptions(r))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		filter = &lineFilter{q, x}
+	}
+	if enc := charset.Detect(data); enc != nil {
+		data = enc.Decode(data)
+		if filter != nil {
+			serveFile(w, file, enc, data, filter)
+			return
+		}
+		if isMarkdown(file) && r.FormValue("view") != "source" {
+			serveMarkdown(w, file, enc, data)
+			return
+		}
+		if notebook.IsNotebook(baseName(file)) && r.FormValue("view") != "source" {
+			if nb, err := notebook.Parse(data); err == nil {
+				serveNotebook(w, file, enc, nb)
+				return
+			}
+		}
+		serveFile(w, file, enc, data, nil)
+		return
+	}
+	serveBinary(w, file, data)
+}
 
-	var buf bytes.Buffer
-	e := html.EscapeString
-	printHeader(&buf, file)
+// previewTypes maps the sniffed content types that are displayed inline to
+// the kind of preview.
+var previewTypes = map[string]string{
+	"image/bmp":                "image",
+	"image/gif":                "image",
+	"image/jpeg":               "image",
+	"image/png":                "image",
+	"image/webp":               "image",
+	"image/x-icon":             "image",
+	"image/vnd.microsoft.icon": "image",
+	"application/pdf":          "pdf",
+}
+
+// serveRaw writes the file data with a sniffed content type. Only the types
+// in previewTypes are served inline; anything else (notably HTML, which
+// would run as same-origin active content) is served as an attachment.
+func serveRaw(w http.ResponseWriter, file string, data []byte) {
+	ctype := http.DetectContentType(data)
+	disposition := "inline"
+	if _, ok := previewTypes[ctype]; !ok {
+		ctype = "application/octet-stream"
+		disposition = "attachment"
+	}
+	if d := mime.FormatMediaType(disposition, map[string]string{"filename": baseName(file)}); d != "" {
+		disposition = d
+	}
+	csp := "sandbox; default-src 'none'; img-src 'self'; style-src 'unsafe-inline'"
+	if ctype == "application/pdf" {
+		// Browsers refuse to render PDFs in sandboxed documents.
+		csp = "default-src 'none'; img-src 'self'; style-src 'unsafe-inline'"
+	}
+	h := w.Header()
+	h.Set("Content-Type", ctype)
+	h.Set("Content-Disposition", disposition)
+	h.Set("Content-Security-Policy", csp)
+	w.Write(data)
+}
+
+// maxHexDump is the number of bytes shown in the hex dump of a binary file.
+const maxHexDump = 64 << 10
+
+func serveBinary(w http.ResponseWriter, file string, data []byte) {
+	d := struct {
+		viewer
+		Name      string
+		Type      string
+		Size      int
+		RawURL    string
+		Preview   string // kind of preview, see previewTypes
+		HexDump   string
+		Truncated int // number of bytes in HexDump if it is truncated
+	}{
+		viewer: newViewer(file),
+		Name:   baseName(file),
+		Type:   http.DetectContentType(data),
+		Size:   len(data),
+		RawURL: showURL(file) + "?raw=1",
+	}
+	d.Preview = previewTypes[d.Type]
+	if d.Preview == "" {
+		if len(data) > maxHexDump {
+			data = data[:maxHexDump]
+			d.Truncated = maxHexDump
+		}
+		d.HexDump = hex.Dump(data)
+	}
+	render(w, http.StatusOK, "binary", d)
+}
+
+// maxSnippetLine is the maximum length of a line of a search result
+// snippet. Longer lines, like minified code, are truncated.
+const maxSnippetLine = 300
+
+// ellipsis marks where a truncated line was cut.
+const ellipsis template.HTML = "…"
+
+// truncateLine returns line if it is at most maxSnippetLine bytes long, or
+// else the part of it around the match at the byte offset at, or its
+// beginning if at is negative, and whether its beginning and end were cut.
+func truncateLine(line []byte, at int) (part []byte, head, tail bool) {
+	if len(line) <= maxSnippetLine {
+		return line, false, false
+	}
+	start := 0
+	if at >= 0 {
+		// some context before the match
+		start = max(0, at-maxSnippetLine/4)
+	}
+	end := min(len(line), start+maxSnippetLine)
+	start = max(0, end-maxSnippetLine)
+	for start > 0 && !utf8.RuneStart(line[start]) {
+		start++
+	}
+	for end < len(line) && !utf8.RuneStart(line[end]) {
+		end--
+	}
+	return line[start:end], start > 0, end < len(line)
+}
+
+// baseName returns the last element of the name of the file, which may be
+// in an archive, without the suffix of its compression, if any: the name of
+// the contents shown.
+func baseName(file string) string {
+	if _, f, ok := archive.Split(file); ok {
+		file = f
+	}
+	return archive.Decompressed(path.Base(file))
+}
+
+// maxDecompressed is the maximum size of the decompressed contents of a
+// compressed file shown in the viewer.
+const maxDecompressed = 256 << 20
+
+// decompress returns the decompressed contents of the compressed file.
+func decompress(file string, data []byte) ([]byte, error) {
+	r, err := archive.Decompress(file, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	data, err = io.ReadAll(io.LimitReader(r, maxDecompressed+1))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	if len(data) > maxDecompressed {
+		return nil, fmt.Errorf("%s: too large to show decompressed", file)
+	}
+	return data, nil
+}
+
+type line struct {
+	N    int
+	Text template.HTML // highlighted
+	Gap  bool          // lines before it are not shown
+}
+
+// lineFilter selects the lines of a file view matching a search query.
+type lineFilter struct {
+	query string // as entered
+	x     *queryExpr
+}
+
+// serveFile writes the numbered lines of the file, only those matching
+// filter if it is not nil.
+func serveFile(w http.ResponseWriter, file string, enc *charset.Encoding, data []byte, filter *lineFilter) {
+	d := struct {
+		viewer
+		RenderedURL string // of the rendered view, if any
+		Query       string // of the filter, if any
+		AllURL      string // of the view of all lines
+		Width       int    // of line numbers
+		Lines       []line
+	}{viewer: newTextViewer(file, enc)}
+	if isMarkdown(file) || notebook.IsNotebook(baseName(file)) {
+		d.RenderedURL = showURL(file)
+	}
+	var matched map[int]bool // numbers of the lines matching filter
+	if filter != nil {
+		d.Query = filter.query
+		d.AllURL = showURL(file)
+		matched = filter.x.matchLines(data)
+	}
 	n := 1 + bytes.Count(data, nl)
 	wid := len(fmt.Sprintf("%d", n))
-	wid = (wid+2+7)&^7 - 2
-	n = 1
-	for len(data) > 0 {
-		var line []byte
-		line, data, _ = bytes.Cut(data, nl)
-		fmt.Fprintf(&buf, "<span id=\"L%d\">%*d  %s\n</span>", n, wid, n, e(string(line)))
-		n++
-	}
-	return buf.Bytes()
-}
-
-// isText reports whether a significant prefix of s looks like correct UTF-8;
-// that is, if it is likely that s is human-readable text.
-func isText(s []byte) bool {
-	const max = 1024 // at least utf8.UTFMax
-	if len(s) > max {
-		s = s[0:max]
-	}
-	for i, c := range string(s) {
-		if i+utf8.UTFMax > len(s) {
-			// last char may be incomplete - ignore
-			break
-		}
-		if c == 0xFFFD || c < ' ' && c != '\n' && c != '\t' && c != '\f' {
-			// decoding error or control character - not a text file
-			return false
-		}
-	}
-	return true
-}
-
-// This map is based on a codesearch test, it's not from a definitive source:
-// https://github.com/google/codesearch/blob/b34f2a0c5ce12be3c9dc28038640afece6bee523/regexp/regexp_test.go#L136
-func backslashEscapeAllPunctuation(s string) string {
-	r := strings.NewReplacer(
-		`!`, `\!`,
-		`"`, `\"`,
-		`#`, `\#`,
-		`$`, `\$`,
-		`%`, `\%`,
-		`&`, `\&`,
-		`'`, `\'`,
-		`(`, `\(`,
-		`)`, `\)`,
-		`*`, `\*`,
-		`+`, `\+`,
-		`,`, `\,`,
-		`-`, `\-`,
-		`.`, `\.`,
-		`/`, `\/`,
-		`:`, `\:`,
-		`;`, `\;`,
-		`<`, `\<`,
-		`=`, `\=`,
-		`>`, `\>`,
-		`?`, `\?`,
-		`@`, `\@`,
-		`[`, `\[`,
-		`\`, `\\`,
-		`]`, `\]`,
-		`^`, `\^`,
-		`_`, `\_`,
-		`{`, `\{`,
-		`|`, `\|`,
-		`}`, `\}`,
-		`~`, `\~`,
-	)
-	return r.Replace(s)
+	d.Width = (wid+2+7)&^7 - 2
+	last := 0 // number of the last line shown
+	for i, l := range highlight.Lines(highlight.Detect(baseName(file)), data) {
+		if filter != nil && !matched[i+1] {
+			continue
+		}
+		d.Lines = append(d.Lines, line{N: i + 1, Text: l, Gap: i > last})
+		last = i + 1
+	}
+	render(w, http.StatusOK, "file", d)
+}
+
+func isMarkdown(file string) bool {
+	switch strings.ToLower(path.Ext(baseName(file))) {
+	case ".md", ".markdown":
+		return true
+	}
+	return false
+}
+
+func serveMarkdown(w http.ResponseWriter, file string, enc *charset.Encoding, data []byte) {
+	d := struct {
+		viewer
+		SourceURL string
+		HTML      template.HTML
+	}{
+		viewer:    newTextViewer(file, enc),
+		SourceURL: showURL(file) + "?view=source",
+		HTML:      markdownRenderer(file).Render(data),
+	}
+	render(w, http.StatusOK, "markdown", d)
+}
+
+// markdownRenderer returns a renderer of the Markdown in file.
+func markdownRenderer(file string) *markdown.Renderer {
+	return &markdown.Renderer{
+		Link: func(dest string) string {
+			return rewriteLink(file, dest, false)
+		},
+		Image: func(src string) string {
+			return rewriteLink(file, src, true)
+		},
+		Code: func(info, code string) template.HTML {
+			var b strings.Builder
+			for _, l := range highlight.Lines(highlight.Lookup(info), []byte(code)) {
+				b.WriteString(string(l))
+				b.WriteString("\n")
+			}
+			return template.HTML(b.String())
+		},
+	}
+}
+
+// rewriteLink rewrites the relative link dest in the document file to the
+// /show/ URL of its target. Links to images refer to the raw data.
+func rewriteLink(file, dest string, image bool) string {
+	if dest == "" || strings.HasPrefix(dest, "#") || strings.HasPrefix(dest, "/") || strings.Contains(dest, ":") {
+		// fragment, absolute or with a scheme
+		return dest
+	}
+	p, frag, hasFrag := strings.Cut(dest, "#")
+	if u, err := url.PathUnescape(p); err == nil {
+		p = u
+	}
+	target := resolveName(file, p)
+	u := showURL(target)
+	if image {
+		u += "?raw=1"
+	}
+	if hasFrag {
+		u += "#" + frag
+	}
+	return u
+}
+
+// resolveName returns the name of the file rel relative to the directory
+// of the file name, which may be in an archive.
+func resolveName(name, rel string) string {
+	if arch, file, ok := archive.Split(name); ok {
+		entry := path.Join(path.Dir("/"+file), rel)
+		return arch + archive.Sep + strings.TrimPrefix(entry, "/")
+	}
+	return path.Join(path.Dir(name), rel)
+}
+
+type nbCell struct {
+	ID      string // anchor
+	Type    string // "code", "markdown" or "raw"
+	Prompt  string // execution count of code cells, like "[3]"
+	HTML    template.HTML
+	Lines   []nbLine // of code and raw cells
+	Outputs []nbOutput
+}
+
+type nbLine struct {
+	ID   string // anchor
+	Text template.HTML
+}
+
+// nbOutput is an output of a code cell: lines of text, an image or HTML.
+type nbOutput struct {
+	ID    string // anchor of its first text line, if any
+	Class string // "stdout", "stderr", "error" or "result"
+	Lines []nbLine
+	Image template.URL // data URL
+	HTML  string       // document shown in a sandboxed frame
+}
+
+// nbImageTypes are the image types of outputs, in order of preference.
+var nbImageTypes = []string{"image/png", "image/jpeg", "image/gif", "image/svg+xml"}
+
+// serveNotebook renders the notebook nb read-only. Markdown cells are
+// rendered, code cells highlighted, and outputs shown as images, as HTML in
+// a sandboxed frame or as text. The anchors of lines are those returned by
+// notebook.Line.Anchor.
+func serveNotebook(w http.ResponseWriter, file string, enc *charset.Encoding, nb *notebook.Notebook) {
+	d := struct {
+		viewer
+		SourceURL  string
+		JupyterURL string
+		Cells      []nbCell
+	}{
+		viewer:     newTextViewer(file, enc),
+		SourceURL:  showURL(file) + "?view=source",
+		JupyterURL: jupyterLink(file),
+	}
+	md := markdownRenderer(file)
+	lang := highlight.Lookup(nb.Language)
+	for i, c := range nb.Cells {
+		n := i + 1
+		cell := nbCell{ID: fmt.Sprintf("cell-%d", n), Type: c.Type}
+		switch c.Type {
+		case "markdown":
+			cell.HTML = md.Render([]byte(c.Source))
+		case "code":
+			cell.Prompt = "[ ]"
+			if c.ExecutionCount > 0 {
+				cell.Prompt = fmt.Sprintf("[%d]", c.ExecutionCount)
+			}
+			cell.Lines = nbLines(n, false, 1, highlight.Lines(lang, []byte(c.Source)))
+		default:
+			cell.Lines = nbLines(n, false, 1, highlight.Lines(nil, []byte(c.Source)))
+		}
+		lineno := 1 // of the outputs, numbered together
+		for _, o := range c.Outputs {
+			text := o.Lines()
+			out := nbOutput{Class: o.Name}
+			if o.Type != "stream" {
+				out.Class = o.Type
+				if o.Type != "error" {
+					out.Class = "result"
+				}
+			}
+			if len(text) > 0 {
+				out.ID = notebook.Line{Cell: n, Line: lineno, Output: true}.Anchor()
+			}
+			switch {
+			case nbImage(o) != "":
+				out.Image = nbImage(o)
+			case o.Data["text/html"] != "":
+				out.HTML = o.Data["text/html"]
+			default:
+				out.ID = ""
+				var hl []template.HTML
+				for _, l := range text {
+					hl = append(hl, template.HTML(template.HTMLEscapeString(l)))
+				}
+				out.Lines = nbLines(n, true, lineno, hl)
+			}
+			lineno += len(text)
+			if out.Image != "" || out.HTML != "" || len(out.Lines) > 0 {
+				cell.Outputs = append(cell.Outputs, out)
+			}
+		}
+		d.Cells = append(d.Cells, cell)
+	}
+	render(w, http.StatusOK, "notebook", d)
+}
+
+// nbLines returns the lines of cell n, numbered from first.
+func nbLines(n int, output bool, first int, lines []template.HTML) []nbLine {
+	var ls []nbLine
+	for i, l := range lines {
+		id := notebook.Line{Cell: n, Line: first + i, Output: output}.Anchor()
+		ls = append(ls, nbLine{ID: id, Text: l})
+	}
+	return ls
+}
+
+// nbImage returns the data URL of the image of output o, or "" if it has
+// none or its data is not valid.
+func nbImage(o notebook.Output) template.URL {
+	for _, typ := range nbImageTypes {
+		data, ok := o.Data[typ]
+		if !ok {
+			continue
+		}
+		if typ == "image/svg+xml" {
+			// stored as text; an image cannot run its scripts
+			return template.URL("data:" + typ + ";base64," + base64.StdEncoding.EncodeToString([]byte(data)))
+		}
+		data = strings.Join(strings.Fields(data), "")
+		if _, err := base64.StdEncoding.DecodeString(data); err != nil {
+			continue
+		}
+		return template.URL("data:" + typ + ";base64," + data)
+	}
+	return ""
+}
+
+// Notebooks in jupyterDir, relative to the home directory, are served by
+// Jupyter at jupyterURL (see compose.yaml).
+const (
+	jupyterDir = ".sandd/jupyter/work"
+	jupyterURL = "https://jupyter.sd.test/lab/tree/"
+)
+
+// jupyterLink returns the URL of the notebook file in Jupyter, or "" if
+// Jupyter does not serve it.
+func jupyterLink(file string) string {
+	if _, _, ok := archive.Split(file); ok || archive.IsCompressed(file) {
+		return ""
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	rel, err := filepath.Rel(filepath.Join(home, jupyterDir), file)
+	if err != nil || !filepath.IsLocal(rel) {
+		return ""
+	}
+	return jupyterURL + (&url.URL{Path: filepath.ToSlash(rel)}).EscapedPath()
 }
@@ -0,0 +1,70 @@
+package codesearchpatch
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// HasUpper reports whether the search pattern pat has upper case letters,
+// for smart case: a search is case-insensitive unless it does, like
+// ripgrep --smart-case. If regex is set, pat is a regexp, and the letters
+// of escapes like \S and \p{Lu}, of flags like (?U) and of group names do
+// not count; the letters of \Q...\E and escapes like \x41 do.
+func HasUpper(pat string, regex bool) bool {
+	if !regex {
+		return strings.IndexFunc(pat, unicode.IsUpper) >= 0
+	}
+	for i := 0; i < len(pat); i++ {
+		switch c := pat[i]; {
+		case c == '\\' && i+1 < len(pat):
+			i++
+			switch pat[i] {
+			case 'Q':
+				// literal text, up to \E
+				text, _, _ := strings.Cut(pat[i+1:], `\E`)
+				if HasUpper(text, false) {
+					return true
+				}
+				i += len(text) + len(`\E`)
+			case 'x':
+				// \x41 or \x{41}
+				hex := pat[i+1:]
+				if strings.HasPrefix(hex, "{") {
+					hex, _, _ = strings.Cut(hex[1:], "}")
+					i += len(hex) + len("{}")
+				} else {
+					hex = hex[:min(2, len(hex))]
+					i += len(hex)
+				}
+				if r, err := strconv.ParseUint(hex, 16, 32); err == nil && unicode.IsUpper(rune(r)) {
+					return true
+				}
+			case 'p', 'P':
+				// \pL or \p{Lu}
+				if strings.HasPrefix(pat[i+1:], "{") {
+					name, _, _ := strings.Cut(pat[i+2:], "}")
+					i += len(name) + len("{}")
+				} else {
+					i++
+				}
+			}
+			// other escapes are classes like \S, assertions like
+			// \A, octal digits and punctuation
+		case strings.HasPrefix(pat[i:], "(?"):
+			// flags, like (?U) and (?i:, or a group name, like
+			// (?P<Name>
+			if j := strings.IndexAny(pat[i+2:], ":)>"); j >= 0 {
+				i += 2 + j
+			}
+		default:
+			// continuation bytes of characters decode as
+			// utf8.RuneError
+			if r, _ := utf8.DecodeRuneInString(pat[i:]); unicode.IsUpper(r) {
+				return true
+			}
+		}
+	}
+	return false
+}
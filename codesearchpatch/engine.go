@@ -0,0 +1,251 @@
+package codesearchpatch
+
+import (
+	"bytes"
+	"context"
+	"io"
+	goregexp "regexp"
+	"sync"
+	"sync/atomic"
+
+	"github.com/google/codesearch/regexp"
+)
+
+// An Engine searches texts for a regexp, like Grep, but is safe for
+// concurrent use: its configuration is fixed by NewEngine, each search
+// returns its own Result, uses its own copy of the regexps, which are not
+// safe for concurrent use, the buffers are pooled and the matches of all the
+// searches may count against a shared Budget.
+type Engine struct {
+	re      *regexp.Regexp
+	lre     *goregexp.Regexp // derived from re, see Grep.derive
+	lit     *literal
+	near    *Grep     // for Near.Regexp, derived
+	regexps sync.Pool // of *engineRegexps
+	conf    EngineConfig
+}
+
+// engineRegexps are the copies of the regexps of an Engine that a search
+// uses. Each one caches the states of its matcher.
+type engineRegexps struct {
+	re, near *regexp.Regexp
+}
+
+// EngineConfig is the configuration of an Engine.
+type EngineConfig struct {
+	PreContext  int     // number of lines of context before matches
+	PostContext int     // number of lines of context after matches
+	FileLimit   int     // if not 0, the number of matches reported per text
+	Budget      *Budget // if not nil, the limit of the matches of all searches
+	Near        *Near   // if not nil, a condition on the matched lines
+	Multiline   bool    // matches may span lines, see Grep.Multiline
+}
+
+// NewEngine returns an engine searching for re.
+func NewEngine(re *regexp.Regexp, conf EngineConfig) *Engine {
+	g := Grep{Regexp: re}
+	g.derive()
+	e := &Engine{re: re, lre: g.lre, lit: g.lit, conf: conf}
+	if conf.Near != nil {
+		e.near = &Grep{Regexp: conf.Near.Regexp}
+		e.near.derive()
+	}
+	e.regexps.New = func() any {
+		rs := &engineRegexps{re: recompile(e.re)}
+		if e.near != nil {
+			rs.near = recompile(e.near.Regexp)
+		}
+		return rs
+	}
+	return e
+}
+
+// recompile returns a copy of re, which compiled before.
+func recompile(re *regexp.Regexp) *regexp.Regexp {
+	c, err := regexp.Compile(re.String())
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// A Result is the result of a search by an Engine.
+type Result struct {
+	Name    string
+	Matches []Match
+	Skipped int // matches not reported because of FileLimit
+}
+
+// A Match is a matched line and its context, copied from the text.
+type Match struct {
+	Lineno    int
+	EndLineno int      // of the last line of a Multiline match, or Lineno
+	Line      []byte   // without its newline; the lines of a Multiline match
+	Before    [][]byte // lines of context, without their newlines
+	After     [][]byte
+	Start     int   // of the first match in Line
+	End       int   // of the first match in Line
+	Offset    int64 // of the start of the first match in the text
+	Column    int   // of the start of the first match in Line, in characters, from 1
+
+	text               []byte // of the lines, which are slices of it
+	lineStart, lineEnd int    // of Line in text, with its newline
+}
+
+// Context returns the lines of the match like LinesContext: without their
+// common indentation and trailing space. There is one line, unless the match
+// is Multiline. start is the offset of the match in the first one, like
+// m.Start in m.Line.
+func (m *Match) Context() (before, lines, after [][]byte, start int) {
+	before, lines, after, indent := linesContext(len(m.Before), len(m.After), m.text, m.lineStart, m.lineEnd)
+	start = min(max(m.Start-indent, 0), len(lines[0]))
+	return before, lines, after, start
+}
+
+// A Budget is a limit of the matches reported by searches, which may run
+// concurrently.
+type Budget struct {
+	limit   int64
+	used    atomic.Int64
+	limited atomic.Bool
+}
+
+// NewBudget returns a budget of limit matches.
+func NewBudget(limit int) *Budget {
+	return &Budget{limit: int64(limit)}
+}
+
+// take takes a match from the budget, reporting whether one was left.
+func (b *Budget) take() bool {
+	for {
+		n := b.used.Load()
+		if n >= b.limit {
+			b.limited.Store(true)
+			return false
+		}
+		if b.used.CompareAndSwap(n, n+1) {
+			return true
+		}
+	}
+}
+
+// Used returns the number of matches reported.
+func (b *Budget) Used() int {
+	return int(b.used.Load())
+}
+
+// Limited reports whether a search stopped because no matches were left.
+func (b *Budget) Limited() bool {
+	return b.limited.Load()
+}
+
+// bufPool holds the initial buffers of searches. Buffers grown for long
+// lines are not kept.
+var bufPool = sync.Pool{
+	New: func() any {
+		b := make([]byte, bufSize)
+		return &b
+	},
+}
+
+// Search searches r, the contents of the named file, like
+// Grep.ReaderContext. It returns the result, as far as the search went, and
+// ErrLimit if it stopped because the budget ran out, ctx.Err() if ctx was
+// canceled, or the error reading r.
+func (e *Engine) Search(ctx context.Context, r io.Reader, name string) (*Result, error) {
+	g, put := e.grep()
+	defer put()
+	res := &Result{Name: name}
+	g.N = true
+	g.FileLimit = e.conf.FileLimit
+	g.PreContext = e.conf.PreContext
+	g.PostContext = e.conf.PostContext
+	g.OnMatch = func(buf []byte, name string, lineno, lineStart, lineEnd int, pos MatchPos) {
+		res.Matches = append(res.Matches, e.newMatch(buf, lineno, lineStart, lineEnd, pos))
+	}
+	g.budget = e.conf.Budget
+	err := g.ReaderContext(ctx, r, name)
+	res.Skipped = g.FileSkipped
+	return res, err
+}
+
+// Match reports whether r, the contents of the named file, has a match. It
+// stops at the first one, which does not count against the budget.
+func (e *Engine) Match(ctx context.Context, r io.Reader, name string) (bool, error) {
+	g, put := e.grep()
+	defer put()
+	g.L = true
+	err := g.ReaderContext(ctx, r, name)
+	return g.Match, err
+}
+
+// grep returns a Grep for a search and the function putting its regexps
+// and buffers back in their pools when it is done.
+func (e *Engine) grep() (g *Grep, put func()) {
+	rs := e.regexps.Get().(*engineRegexps)
+	bp := bufPool.Get().(*[]byte)
+	g = &Grep{
+		Regexp:    rs.re,
+		Stdout:    io.Discard,
+		Stderr:    io.Discard,
+		Near:      e.conf.Near,
+		Multiline: e.conf.Multiline,
+		buf:       *bp,
+		derived:   rs.re,
+		lre:       e.lre,
+		lit:       e.lit,
+	}
+	if e.near == nil {
+		return g, func() {
+			e.regexps.Put(rs)
+			bufPool.Put(bp)
+		}
+	}
+	nbp := bufPool.Get().(*[]byte)
+	near := *e.near
+	near.Regexp, near.derived = rs.near, rs.near
+	near.buf = *nbp
+	g.nearGrep, g.nearOf = &near, e.conf.Near.Regexp
+	return g, func() {
+		e.regexps.Put(rs)
+		bufPool.Put(bp)
+		bufPool.Put(nbp)
+	}
+}
+
+// newMatch returns the match of the line buf[lineStart:lineEnd] at pos.
+func (e *Engine) newMatch(buf []byte, lineno, lineStart, lineEnd int, pos MatchPos) Match {
+	start := lineStart - lineSuffixLen(buf[:lineStart], e.conf.PreContext)
+	end := lineEnd + linePrefixLen(buf[lineEnd:], e.conf.PostContext)
+	text := bytes.Clone(buf[start:end])
+	m := Match{
+		Lineno:    lineno,
+		Start:     pos.Start - lineStart,
+		End:       pos.End - lineStart,
+		Offset:    pos.Offset,
+		Column:    pos.Column,
+		text:      text,
+		lineStart: lineStart - start,
+		lineEnd:   lineEnd - start,
+	}
+	m.Before = splitLines(text[:m.lineStart])
+	m.Line = bytes.TrimSuffix(text[m.lineStart:m.lineEnd], nl)
+	m.EndLineno = lineno + bytes.Count(m.Line, nl)
+	m.After = splitLines(text[m.lineEnd:])
+	return m
+}
+
+// splitLines returns the lines of b without their newlines.
+func splitLines(b []byte) [][]byte {
+	var lines [][]byte
+	for len(b) > 0 {
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 {
+			lines = append(lines, b)
+			break
+		}
+		lines = append(lines, b[:i])
+		b = b[i+1:]
+	}
+	return lines
+}
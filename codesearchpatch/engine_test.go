@@ -0,0 +1,64 @@
+package codesearchpatch
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/codesearch/regexp"
+)
+
+// TestEngineConcurrent runs searches of an Engine concurrently; run it with
+// -race. The regexps match more than literal text, so they use the matchers
+// of package codesearch/regexp, which fill in their caches as they match.
+func TestEngineConcurrent(t *testing.T) {
+	re, err := regexp.Compile(`alpha\d+ b.ta`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nre, err := regexp.Compile(`gam+a`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var text strings.Builder
+	for i := range 200 {
+		fmt.Fprintf(&text, "alpha%d beta\n", i)
+		if i%2 == 0 {
+			text.WriteString("gamma\n")
+		}
+		text.WriteString("delta\n\n")
+	}
+	for _, conf := range []EngineConfig{
+		{PreContext: 1, PostContext: 1},
+		{Near: &Near{Regexp: nre, Lines: 1}},
+		{Multiline: true},
+	} {
+		e := NewEngine(re, conf)
+		want := 200
+		if conf.Near != nil {
+			want = 100
+		}
+		var wg sync.WaitGroup
+		for range 8 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for range 10 {
+					res, err := e.Search(context.Background(), bytes.NewReader([]byte(text.String())), "text")
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					if len(res.Matches) != want {
+						t.Errorf("%+v: %d matches, want %d", conf, len(res.Matches), want)
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+	}
+}
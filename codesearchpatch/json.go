@@ -0,0 +1,229 @@
+package codesearchpatch
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+	"unicode/utf8"
+)
+
+// The JSON output (Grep.JSON) is a stream of events, one JSON object per
+// line, like the output of ripgrep --json:
+//
+//	{"type":"begin","data":{"path":{"text":"a.go"}}}
+//	{"type":"context","data":{"path":…,"lines":{"text":"…\n"},"line_number":1,"absolute_offset":0,"submatches":[]}}
+//	{"type":"match","data":{"path":…,"lines":…,"line_number":2,"absolute_offset":10,"submatches":[{"match":{"text":"x"},"start":4,"end":5}]}}
+//	{"type":"end","data":{"path":…,"binary_offset":null,"stats":…}}
+//
+// begin, match, context and end events are written for each file with
+// matches, and a summary event with the totals by Grep.Summary. Text that is
+// not valid UTF-8 is written as {"bytes": base64} instead of {"text": …}.
+// Offsets are in bytes: absolute_offset of the line in the text read, start
+// and end of the submatches in the line.
+
+type jsonEvent struct {
+	Type string `json:"type"`
+	Data any    `json:"data"`
+}
+
+type jsonText struct {
+	Text  *string `json:"text,omitempty"`
+	Bytes []byte  `json:"bytes,omitempty"`
+}
+
+func newJSONText(b []byte) jsonText {
+	if !utf8.Valid(b) {
+		return jsonText{Bytes: b}
+	}
+	s := string(b)
+	return jsonText{Text: &s}
+}
+
+type jsonBegin struct {
+	Path jsonText `json:"path"`
+}
+
+type jsonLine struct {
+	Path           jsonText       `json:"path"`
+	Lines          jsonText       `json:"lines"`
+	LineNumber     int            `json:"line_number"`
+	AbsoluteOffset int64          `json:"absolute_offset"`
+	Submatches     []jsonSubmatch `json:"submatches"`
+}
+
+type jsonSubmatch struct {
+	Match jsonText `json:"match"`
+	Start int      `json:"start"`
+	End   int      `json:"end"`
+}
+
+type jsonEnd struct {
+	Path         jsonText  `json:"path"`
+	BinaryOffset *int64    `json:"binary_offset"`
+	Stats        jsonStats `json:"stats"`
+}
+
+type jsonSummary struct {
+	ElapsedTotal jsonDuration `json:"elapsed_total"`
+	Stats        jsonStats    `json:"stats"`
+}
+
+type jsonStats struct {
+	Elapsed           jsonDuration `json:"elapsed"`
+	Searches          int          `json:"searches"`
+	SearchesWithMatch int          `json:"searches_with_match"`
+	BytesSearched     int64        `json:"bytes_searched"`
+	BytesPrinted      int64        `json:"bytes_printed"`
+	MatchedLines      int          `json:"matched_lines"`
+	Matches           int          `json:"matches"`
+}
+
+func (s *jsonStats) add(t jsonStats) {
+	s.Elapsed = newJSONDuration(s.Elapsed.d + t.Elapsed.d)
+	s.Searches += t.Searches
+	s.SearchesWithMatch += t.SearchesWithMatch
+	s.BytesSearched += t.BytesSearched
+	s.BytesPrinted += t.BytesPrinted
+	s.MatchedLines += t.MatchedLines
+	s.Matches += t.Matches
+}
+
+type jsonDuration struct {
+	Secs  int64  `json:"secs"`
+	Nanos int    `json:"nanos"`
+	Human string `json:"human"`
+	d     time.Duration
+}
+
+func newJSONDuration(d time.Duration) jsonDuration {
+	return jsonDuration{
+		Secs:  int64(d / time.Second),
+		Nanos: int(d % time.Second),
+		Human: fmt.Sprintf("%.6fs", d.Seconds()),
+		d:     d,
+	}
+}
+
+// jsonFile is the state of the JSON output for a file.
+type jsonFile struct {
+	path  jsonText
+	start time.Time
+	begun bool // begin event written
+	last  int  // number of the last line written
+	stats jsonStats
+}
+
+func (g *Grep) newJSONFile(name string) *jsonFile {
+	if g.jsonStart.IsZero() {
+		g.jsonStart = time.Now()
+	}
+	return &jsonFile{
+		path:  newJSONText([]byte(name)),
+		start: time.Now(),
+		stats: jsonStats{Searches: 1},
+	}
+}
+
+func (g *Grep) writeJSON(f *jsonFile, typ string, data any) {
+	b, err := json.Marshal(jsonEvent{typ, data})
+	if err != nil {
+		// only plain data is marshaled
+		panic(err)
+	}
+	b = append(b, '\n')
+	g.Stdout.Write(b)
+	if f != nil {
+		f.stats.BytesPrinted += int64(len(b))
+	}
+}
+
+// jsonMatch writes the events of the matched line buf[lineStart:lineEnd],
+// at offset in the text, and of its context.
+func (g *Grep) jsonMatch(f *jsonFile, buf []byte, lineno, lineStart, lineEnd int, offset int64) {
+	if !f.begun {
+		g.writeJSON(f, "begin", jsonBegin{f.path})
+		f.begun = true
+		f.stats.SearchesWithMatch = 1
+	}
+
+	// lines before, unless already written
+	start := lineStart - lineSuffixLen(buf[:lineStart], g.PreContext)
+	n := lineno - bytes.Count(buf[start:lineStart], nl)
+	for start < lineStart {
+		end := start + bytes.IndexByte(buf[start:lineStart], '\n') + 1
+		if n > f.last {
+			g.jsonLine(f, "context", buf[start:end], n, offset+int64(start), nil)
+		}
+		start = end
+		n++
+	}
+
+	line := buf[lineStart:lineEnd]
+	var subs []jsonSubmatch
+	text := bytes.TrimSuffix(line, nl)
+	for _, loc := range g.findAllIndex(text, -1) {
+		subs = append(subs, jsonSubmatch{newJSONText(text[loc[0]:loc[1]]), loc[0], loc[1]})
+	}
+	g.jsonLine(f, "match", line, lineno, offset+int64(lineStart), subs)
+	f.stats.MatchedLines++
+	f.stats.Matches += len(subs)
+
+	// lines after, up to the next match, which is written as a match
+	start = lineEnd
+	end := lineEnd + linePrefixLen(buf[lineEnd:], g.PostContext)
+	for n = lineno + 1; start < end; n++ {
+		e := start + bytes.IndexByte(buf[start:end], '\n') + 1
+		if e == start {
+			e = end
+		}
+		if g.match(buf[start:e], true, true) >= 0 && g.nearOK(n) {
+			break
+		}
+		g.jsonLine(f, "context", buf[start:e], n, offset+int64(start), nil)
+		start = e
+	}
+}
+
+func (g *Grep) jsonLine(f *jsonFile, typ string, line []byte, lineno int, offset int64, subs []jsonSubmatch) {
+	if subs == nil {
+		subs = []jsonSubmatch{}
+	}
+	g.writeJSON(f, typ, jsonLine{
+		Path:           f.path,
+		Lines:          newJSONText(line),
+		LineNumber:     lineno,
+		AbsoluteOffset: offset,
+		Submatches:     subs,
+	})
+	f.last = lineno
+}
+
+// jsonEnd writes the end event of the file, if it had matches, and adds
+// its statistics to the totals, after searching the given number of bytes.
+// The bytes printed are those of the events before the end event, in the
+// end event and in the totals alike.
+func (g *Grep) jsonEnd(f *jsonFile, searched int64) {
+	f.stats.BytesSearched = searched
+	f.stats.Elapsed = newJSONDuration(time.Since(f.start))
+	if f.begun {
+		g.writeJSON(nil, "end", jsonEnd{Path: f.path, Stats: f.stats})
+	}
+	g.jsonStats.add(f.stats)
+}
+
+// Summary writes the summary event of the JSON output with the totals of
+// the files searched, after the last call to Reader.
+func (g *Grep) Summary() {
+	if !g.JSON {
+		return
+	}
+	var elapsed time.Duration
+	if !g.jsonStart.IsZero() {
+		elapsed = time.Since(g.jsonStart)
+	}
+	g.writeJSON(nil, "summary", jsonSummary{
+		ElapsedTotal: newJSONDuration(elapsed),
+		Stats:        g.jsonStats,
+	})
+}
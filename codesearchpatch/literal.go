@@ -0,0 +1,114 @@
+package codesearchpatch
+
+import (
+	"bytes"
+	"regexp/syntax"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/google/codesearch/regexp"
+)
+
+// A literal is the text that a regexp matching only literal text matches,
+// like the queries of csweb in literal mode. Grep searches for it with
+// bytes.Index instead of the regexp matcher.
+type literal struct {
+	text []byte // in lower case if fold
+	fold bool   // ASCII letters match case-insensitively
+}
+
+// newLiteral returns the literal that re matches, or nil if re matches
+// more than literal text on a line or folds case beyond ASCII.
+func newLiteral(re *regexp.Regexp) *literal {
+	s := re.Syntax
+	if s.Op != syntax.OpLiteral || len(s.Rune) == 0 {
+		return nil
+	}
+	l := &literal{fold: s.Flags&syntax.FoldCase != 0}
+	for _, r := range s.Rune {
+		if r == '\n' {
+			return nil
+		}
+		if l.fold {
+			// like k, which matches K and the Kelvin sign K
+			for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+				if f >= utf8.RuneSelf {
+					return nil
+				}
+			}
+			r = lowerASCII(r)
+		}
+		l.text = utf8.AppendRune(l.text, r)
+	}
+	return l
+}
+
+func lowerASCII[T rune | byte](c T) T {
+	if 'A' <= c && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
+
+func upperASCII(c byte) byte {
+	if 'a' <= c && c <= 'z' {
+		return c - 'a' + 'A'
+	}
+	return c
+}
+
+// index returns the index of the first instance of the literal in b, or -1.
+func (l *literal) index(b []byte) int {
+	if !l.fold {
+		return bytes.Index(b, l.text)
+	}
+	// Check where the first byte is, in either case.
+	c, uc := l.text[0], upperASCII(l.text[0])
+	next, unext := -1, -1 // of c and uc, or len(b)
+	for i := 0; i+len(l.text) <= len(b); {
+		if next < i {
+			next = indexByteFrom(b, c, i)
+		}
+		if unext < i {
+			unext = indexByteFrom(b, uc, i)
+		}
+		j := min(next, unext)
+		if j+len(l.text) > len(b) {
+			break
+		}
+		if l.equalFold(b[j : j+len(l.text)]) {
+			return j
+		}
+		i = j + 1
+	}
+	return -1
+}
+
+// indexByteFrom returns the index of the first instance of c in b at or
+// after i, or len(b).
+func indexByteFrom(b []byte, c byte, i int) int {
+	if j := bytes.IndexByte(b[i:], c); j >= 0 {
+		return i + j
+	}
+	return len(b)
+}
+
+// equalFold reports whether b is the text of the folding literal.
+func (l *literal) equalFold(b []byte) bool {
+	for i, c := range b {
+		if lowerASCII(c) != l.text[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// match is the literal's Regexp.Match: it returns the index of the end of
+// the line of the first instance of the literal in b, or -1.
+func (l *literal) match(b []byte) int {
+	i := l.index(b)
+	if i < 0 {
+		return -1
+	}
+	return indexByteFrom(b, '\n', i+len(l.text))
+}
@@ -3,6 +3,19 @@
 // Changelog:
 //  - add Grep.OnMatch
 //  - export lineContext
+//  - grow the buffer for lines longer than it, up to maxBuf, and scan
+//    longer lines in windows without misnumbering the lines after them
+//  - report the byte offset and column of matches to OnMatch and, with
+//    Grep.Column, in the N output
+//  - add Grep.JSON, JSON Lines output like ripgrep --json (json.go)
+//  - add Grep.ReaderContext, which can be canceled and returns errors
+//  - add Grep.FileLimit, a limit of the matches reported per file
+//  - add Engine, a Grep for concurrent use (engine.go)
+//  - add WholeWord (word.go) and report its word as the match
+//  - search for literal text without the regexp matcher (literal.go)
+//  - add Grep.Near, a proximity condition on the matched lines (near.go)
+//  - add Grep.Multiline, for matches spanning lines, and SpaceInsensitive
+//    (space.go)
 //
 // Original notice:
 //  Copyright 2020 The Go Authors. All rights reserved.
@@ -13,10 +26,15 @@ package codesearchpatch
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"html"
 	"io"
+	goregexp "regexp"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/google/codesearch/regexp"
 )
@@ -33,17 +51,136 @@ type Grep struct {
 	V bool // V flag - print non-matching lines (only for cgrep, not csearch)
 
 	HTML    bool // emit HTML output for csweb
+	JSON    bool // emit JSON Lines events (see json.go); call Summary when done
 	Match   bool // were any matches found?
 	Matches int  // how many matches were found?
 	Limit   int  // stop after this many matches
 	Limited bool // stopped because of limit
 
-	PreContext  int // number of lines to print after
-	PostContext int // number of lines to print before
+	// FileLimit, if not 0, is the number of matches reported per file.
+	// Further matches in the file are counted in FileSkipped, not in
+	// Matches, so they do not count against Limit.
+	FileLimit   int
+	FileSkipped int // matches not reported in the last file searched
+
+	// Near, if not nil, is a condition on the matched lines: the lines
+	// not satisfying it are not matches. The file is read into memory.
+	Near *Near
+
+	// Multiline, if set, lets matches of Regexp span lines, like those of
+	// SpaceInsensitive patterns. The file is read into memory and searched
+	// with package regexp. Only the L, C and N outputs and OnMatch are
+	// supported; the lines that a match spans are its matched line.
+	Multiline bool
+
+	PreContext  int  // number of lines to print after
+	PostContext int  // number of lines to print before
+	Column      bool // with N, print the column of the match, like "file:line:col:"
 	// custom callback on match
-	OnMatch func(buf []byte, name string, lineno, lineStart, lineEnd int)
+	OnMatch func(buf []byte, name string, lineno, lineStart, lineEnd int, pos MatchPos)
+
+	buf     []byte
+	derived *regexp.Regexp   // Regexp that lre and lit are derived from
+	lre     *goregexp.Regexp // Regexp, for finding matches in lines
+	lit     *literal         // Regexp, if it matches literal text
+	budget  *Budget          // of an Engine, instead of Limit
+
+	nearGrep  *Grep          // searches for nearOf, or a copy of it
+	nearOf    *regexp.Regexp // Near.Regexp when nearGrep was made
+	nearLines []int          // numbers of the lines that Near.Regexp matches
+
+	jsonStart time.Time // of the first search
+	jsonStats jsonStats // totals
+}
+
+// MatchPos is the position of the first match in a matched line.
+type MatchPos struct {
+	Start, End int   // of the match in the buffer passed to OnMatch
+	Offset     int64 // of the start of the match in the text read
+	Column     int   // of the start of the match in its line, in characters, from 1
+}
+
+// matchPos returns the position of the first match in buf[lineStart:lineEnd].
+// The line begins lineRunes characters before buf if lineStart is 0, and
+// buf begins at offset in the text.
+func (g *Grep) matchPos(buf []byte, lineStart, lineEnd int, offset int64, lineRunes int) MatchPos {
+	start, end := lineStart, lineStart
+	if locs := g.findAllIndex(buf[lineStart:lineEnd], 1); locs != nil {
+		start, end = lineStart+locs[0][0], lineStart+locs[0][1]
+	}
+	col := 1 + utf8.RuneCount(buf[lineStart:start])
+	if lineStart == 0 {
+		col += lineRunes
+	}
+	return MatchPos{Start: start, End: end, Offset: offset + int64(start), Column: col}
+}
+
+// derive sets the fields derived from Regexp, when it changes: lre, Regexp
+// compiled by package regexp, for finding the matches in a matched line, or
+// nil if it does not compile, and lit.
+func (g *Grep) derive() {
+	if g.derived == g.Regexp {
+		return
+	}
+	// The syntax is the same; codesearch's regexp reports only the matched
+	// lines.
+	g.lre, _ = goregexp.Compile(g.Regexp.String())
+	g.lit = newLiteral(g.Regexp)
+	g.derived = g.Regexp
+}
 
-	buf []byte
+// match returns the index of the end of the first matched line in b, like
+// Regexp.Match.
+func (g *Grep) match(b []byte, beginText, endText bool) int {
+	if g.lit != nil {
+		return g.lit.match(b)
+	}
+	return g.Regexp.Match(b, beginText, endText)
+}
+
+// findAllIndex returns the locations of the first n matches in the line, or
+// of all of them if n < 0, like FindAllIndex of package regexp. The matches
+// of WholeWord patterns are their words. The search for the next one starts
+// after the word, as if the line began there, so words separated by a single
+// character are all found.
+func (g *Grep) findAllIndex(line []byte, n int) [][]int {
+	g.derive()
+	if g.lit != nil {
+		var locs [][]int
+		for start := 0; len(locs) != n; {
+			i := g.lit.index(line[start:])
+			if i < 0 {
+				break
+			}
+			loc := []int{start + i, start + i + len(g.lit.text)}
+			locs = append(locs, loc)
+			start = loc[1]
+		}
+		return locs
+	}
+	re := g.lre
+	if re == nil {
+		return nil
+	}
+	w := re.SubexpIndex(wordGroup)
+	if w < 0 {
+		return re.FindAllIndex(line, n)
+	}
+	var locs [][]int
+	for start := 0; start <= len(line) && len(locs) != n; {
+		m := re.FindSubmatchIndex(line[start:])
+		if m == nil {
+			break
+		}
+		loc := []int{start + m[2*w], start + m[2*w+1]}
+		locs = append(locs, loc)
+		if loc[1] > start {
+			start = loc[1]
+		} else {
+			start++
+		}
+	}
+	return locs
 }
 
 func (g *Grep) esc(s string) string {
@@ -68,58 +205,138 @@ func countNL(b []byte) int {
 	return n
 }
 
+// bufSize is the initial size of the buffer of Grep. It grows up to maxBuf
+// to hold longer lines; lines longer than that are scanned in windows of
+// maxBuf bytes, so matches spanning windows are missed.
+const (
+	bufSize = 1 << 20
+	maxBuf  = 64 << 20
+)
+
+// ErrLimit is returned by ReaderContext when it stops at Limit matches, and
+// by Engine.Search when the budget runs out.
+var ErrLimit = errors.New("match limit reached")
+
+// Reader searches r, the contents of the named file, and prints errors
+// reading it to Stderr. See ReaderContext.
 func (g *Grep) Reader(r io.Reader, name string) {
+	err := g.ReaderContext(context.Background(), r, name)
+	if err != nil && err != ErrLimit {
+		fmt.Fprintf(g.Stderr, "%s: %v\n", g.esc(name), err)
+	}
+}
+
+// ReaderContext searches r, the contents of the named file. It stops if ctx
+// is canceled, checked before reading each chunk of r, and returns ctx.Err().
+// It returns ErrLimit if it stops at Limit matches (and sets Limited), and
+// the error reading r, if any, after searching what was read.
+func (g *Grep) ReaderContext(ctx context.Context, r io.Reader, name string) error {
 	if g.buf == nil {
-		g.buf = make([]byte, 1<<20)
+		g.buf = make([]byte, bufSize)
 	}
+	g.derive()
 	var (
 		buf        = g.buf[:0]
-		needLineno = g.N || g.HTML
+		needLineno = g.N || g.HTML || g.JSON || g.Near != nil
 		lineno     = 1
 		count      = 0
 		prefix     = ""
 		beginText  = true
 		endText    = false
+		window     = false // buf ends within a line longer than maxBuf
+		skipLine   = false // rest of the matched line is in the next window
+		offset     int64   // of buf in the text
+		lineRunes  = 0     // characters of the line at buf[0] in earlier windows
+		read       int64   // bytes read
+		jf         *jsonFile
+		reported   = 0 // matches reported in the file
 	)
+	g.FileSkipped = 0
+	if g.JSON {
+		jf = g.newJSONFile(name)
+		defer func() { g.jsonEnd(jf, read) }()
+	}
 	if !g.H {
 		prefix = name + ":"
 	}
 	chunkStart := 0
+	var readErr error
+	if g.Near != nil || g.Multiline {
+		// The lines after a matched line are needed to know whether it
+		// is a match.
+		var text []byte
+		text, readErr = io.ReadAll(r)
+		if g.Near != nil {
+			if err := g.findNear(ctx, text); err != nil {
+				return err
+			}
+		}
+		if g.Multiline {
+			read = int64(len(text))
+			if err := g.multiline(ctx, text, name); err != nil {
+				return err
+			}
+			return readErr
+		}
+		r = bytes.NewReader(text)
+	}
 	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		n, err := io.ReadFull(r, buf[len(buf):cap(buf)])
 		buf = buf[:len(buf)+n]
+		read += int64(n)
 		end := len(buf)
+		window = false
 		if err == nil {
 			// Stop scan before trailing fragment of a line;
 			// also stop before g.PostContext whole lines,
 			// so we know we'll have the context we need to print.
 			d := lineSuffixLen(buf, g.PostContext+1)
+			if d == len(buf) && cap(buf) < maxBuf {
+				// Line longer than the buffer; double it, up to
+				// maxBuf. (slices.Grow and append may allocate
+				// more than asked for.)
+				nb := make([]byte, len(buf), min(2*cap(buf), maxBuf))
+				buf = nb[:copy(nb, buf)]
+				continue
+			}
 			if d < len(buf) {
 				end = len(buf) - d
+			} else {
+				window = true
+				// Don't split a character between windows.
+				for i := 1; i <= utf8.UTFMax && i <= end; i++ {
+					if utf8.RuneStart(buf[end-i]) {
+						if !utf8.FullRune(buf[end-i : end]) {
+							end -= i
+						}
+						break
+					}
+				}
 			}
 		} else {
 			endText = true
 		}
+		if skipLine {
+			i := bytes.IndexByte(buf[chunkStart:end], '\n')
+			if i < 0 {
+				chunkStart = end
+			} else {
+				chunkStart += i + 1
+				if needLineno {
+					lineno++
+				}
+				skipLine = false
+			}
+		}
 		for chunkStart < end {
-			m1 := g.Regexp.Match(buf[chunkStart:end], beginText, endText) + chunkStart
+			m1 := g.match(buf[chunkStart:end], beginText, endText) + chunkStart
 			beginText = false
 			if m1 < chunkStart {
 				break
 			}
-			g.Match = true
-			if g.Limit > 0 && g.Matches >= g.Limit {
-				g.Limited = true
-				return
-			}
-			g.Matches++
-			if g.L {
-				if g.HTML {
-					fmt.Fprintf(g.Stdout, "<a href=\"show/%s\">%s</a>\n", g.esc(name), g.esc(name))
-				} else {
-					fmt.Fprintf(g.Stdout, "%s\n", name)
-				}
-				return
-			}
 			lineStart := bytes.LastIndex(buf[chunkStart:m1], nl) + 1 + chunkStart
 			lineEnd := m1 + 1
 			if lineEnd > end {
@@ -128,16 +345,48 @@ func (g *Grep) Reader(r io.Reader, name string) {
 			if needLineno {
 				lineno += countNL(buf[chunkStart:lineStart])
 			}
+			near := g.nearOK(lineno)
+			skip := !near || g.FileLimit > 0 && reported >= g.FileLimit
+			switch {
+			case !near:
+				// not a match
+			case skip:
+				g.FileSkipped++
+			case g.Limit > 0 && g.Matches >= g.Limit || g.budget != nil && !g.budget.take():
+				g.Limited = true
+				return ErrLimit
+			default:
+				g.Matches++
+				reported++
+			}
+			if near {
+				g.Match = true
+			}
+			if near && g.L {
+				if g.HTML {
+					fmt.Fprintf(g.Stdout, "<a href=\"show/%s\">%s</a>\n", g.esc(name), g.esc(name))
+				} else {
+					fmt.Fprintf(g.Stdout, "%s\n", name)
+				}
+				return nil
+			}
 			line := buf[lineStart:lineEnd]
+			var pos MatchPos
+			if !skip && (g.OnMatch != nil || g.N && g.Column) {
+				pos = g.matchPos(buf, lineStart, lineEnd, offset, lineRunes)
+			}
 			nl := ""
 			if len(line) == 0 || line[len(line)-1] != '\n' {
 				nl = "\n"
 			}
 			switch {
+			case skip:
 			case g.C:
 				count++
+			case g.JSON:
+				g.jsonMatch(jf, buf, lineno, lineStart, lineEnd, offset)
 			case g.OnMatch != nil:
-				g.OnMatch(buf, name, lineno, lineStart, lineEnd)
+				g.OnMatch(buf, name, lineno, lineStart, lineEnd, pos)
 			case g.PreContext+g.PostContext > 0:
 				fmt.Fprintf(g.Stdout, "%s%d:\n", prefix, lineno)
 				before, match, after := LineContext(g.PreContext, g.PostContext, buf, lineStart, lineEnd)
@@ -150,11 +399,19 @@ func (g *Grep) Reader(r io.Reader, name string) {
 				}
 			case g.HTML:
 				fmt.Fprintf(g.Stdout, "<a href=\"/show/%s?q=%s#L%d\">%s:%d</a>:%s%s", g.esc(strings.ReplaceAll(name, "#", ">")), g.esc(g.Regexp.String()), lineno, g.esc(name), lineno, g.esc(string(line)), nl)
+			case g.N && g.Column:
+				fmt.Fprintf(g.Stdout, "%s%d:%d:%s%s", prefix, lineno, pos.Column, line, nl)
 			case g.N:
 				fmt.Fprintf(g.Stdout, "%s%d:%s%s", prefix, lineno, line, nl)
 			default:
 				fmt.Fprintf(g.Stdout, "%s%s%s", prefix, line, nl)
 			}
+			if window && lineEnd == end && buf[lineEnd-1] != '\n' {
+				// Line continues in the next window.
+				skipLine = true
+				chunkStart = end
+				break
+			}
 			if needLineno {
 				lineno++
 			}
@@ -165,16 +422,27 @@ func (g *Grep) Reader(r io.Reader, name string) {
 		}
 		// Slide pre-context and unprocessed bytes down to start of buffer.
 		d := lineSuffixLen(buf[:end], g.PreContext)
-		if d == end {
+		if d == end || window {
 			// Not enough room; give up on context.
 			d = 0
 		}
+		if window {
+			// The last line continues in the next window.
+			if i := bytes.LastIndexByte(buf[:end], '\n'); i >= 0 {
+				lineRunes = utf8.RuneCount(buf[i+1 : end])
+			} else {
+				lineRunes += utf8.RuneCount(buf[:end])
+			}
+		} else {
+			lineRunes = 0
+		}
+		offset += int64(end - d)
 		n = copy(buf, buf[end-d:])
 		buf = buf[:n]
 		chunkStart = d
 		if endText && err != nil {
 			if err != io.EOF && err != io.ErrUnexpectedEOF {
-				fmt.Fprintf(g.Stderr, "%s: %v\n", g.esc(name), err)
+				readErr = err
 			}
 			break
 		}
@@ -186,6 +454,7 @@ func (g *Grep) Reader(r io.Reader, name string) {
 			fmt.Fprintf(g.Stdout, "%s: %d\n", name, count)
 		}
 	}
+	return readErr
 }
 
 func lineSuffixLen(buf []byte, n int) int {
@@ -217,10 +486,31 @@ func linePrefixLen(buf []byte, lines int) int {
 
 // LineContext returns the given line and the surrounding lines.
 func LineContext(numBefore, numAfter int, buf []byte, lineStart, lineEnd int) (before [][]byte, line []byte, after [][]byte) {
+	before, lines, after := LinesContext(numBefore, numAfter, buf, lineStart, lineEnd)
+	return before, lines[0], after
+}
+
+// LinesContext is LineContext for buf[lineStart:lineEnd], which may be
+// several lines, like those of a Multiline match. It returns them, at least
+// one.
+func LinesContext(numBefore, numAfter int, buf []byte, lineStart, lineEnd int) (before, lines, after [][]byte) {
+	before, lines, after, _ = linesContext(numBefore, numAfter, buf, lineStart, lineEnd)
+	return
+}
+
+// linesContext is LinesContext, also returning the length of the common
+// indentation cut from the lines.
+func linesContext(numBefore, numAfter int, buf []byte, lineStart, lineEnd int) (before, lines, after [][]byte, indent int) {
 	beforeChunk := buf[lineStart-lineSuffixLen(buf[:lineStart], numBefore) : lineStart]
 	afterChunk := buf[lineEnd : lineEnd+linePrefixLen(buf[lineEnd:], numAfter)]
 
-	line = chomp(buf[lineStart:lineEnd])
+	lines = bytes.SplitAfter(buf[lineStart:lineEnd], nl)
+	if len(lines) > 1 && len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+	for i := range lines {
+		lines[i] = chomp(lines[i])
+	}
 	before = bytes.SplitAfter(beforeChunk, nl)
 	if len(before[len(before)-1]) == 0 {
 		before = before[:len(before)-1]
@@ -237,7 +527,9 @@ func LineContext(numBefore, numAfter int, buf []byte, lineStart, lineEnd int) (b
 	}
 
 	var prefix []byte
-	prefix = updatePrefix(prefix, line)
+	for _, l := range lines {
+		prefix = updatePrefix(prefix, l)
+	}
 	for _, l := range before {
 		prefix = updatePrefix(prefix, l)
 	}
@@ -245,14 +537,16 @@ func LineContext(numBefore, numAfter int, buf []byte, lineStart, lineEnd int) (b
 		prefix = updatePrefix(prefix, l)
 	}
 
-	line = cutPrefix(line, prefix)
+	for i, l := range lines {
+		lines[i] = cutPrefix(l, prefix)
+	}
 	for i, l := range before {
 		before[i] = cutPrefix(l, prefix)
 	}
 	for i, l := range after {
 		after[i] = cutPrefix(l, prefix)
 	}
-	return
+	return before, lines, after, len(prefix)
 }
 
 func updatePrefix(prefix, line []byte) []byte {
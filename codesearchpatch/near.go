@@ -0,0 +1,51 @@
+package codesearchpatch
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"slices"
+
+	"github.com/google/codesearch/regexp"
+)
+
+// A Near is a proximity condition on the lines matched by a Grep: that
+// another regexp matches within a number of lines of them, or, negated, that
+// it does not, like finding the db.Query calls without a rows.Close after
+// them.
+type Near struct {
+	Regexp *regexp.Regexp // regexp to search for around the matched lines
+	Lines  int            // distance in lines, 0 for the matched line only
+	Not    bool           // the matched lines must not have Regexp near them
+}
+
+// findNear sets nearLines to the numbers of the lines of text that
+// Near.Regexp matches, found by a Grep.
+func (g *Grep) findNear(ctx context.Context, text []byte) error {
+	ng := g.nearGrep
+	if ng == nil || g.nearOf != g.Near.Regexp {
+		ng = &Grep{Regexp: g.Near.Regexp}
+		g.nearGrep, g.nearOf = ng, g.Near.Regexp
+	}
+	ng.Stdout, ng.Stderr = io.Discard, io.Discard
+	ng.N = true
+	g.nearLines = g.nearLines[:0]
+	ng.OnMatch = func(buf []byte, name string, lineno, lineStart, lineEnd int, pos MatchPos) {
+		g.nearLines = append(g.nearLines, lineno)
+	}
+	return ng.ReaderContext(ctx, bytes.NewReader(text), "")
+}
+
+// nearOK reports whether the line lineno, matched by Regexp, is a match:
+// whether it satisfies Near, if any.
+func (g *Grep) nearOK(lineno int) bool {
+	return g.Near == nil || g.near(lineno)
+}
+
+// near reports whether the line lineno satisfies Near.
+func (g *Grep) near(lineno int) bool {
+	n := g.Near
+	i, _ := slices.BinarySearch(g.nearLines, lineno-n.Lines)
+	found := i < len(g.nearLines) && g.nearLines[i] <= lineno+n.Lines
+	return found != n.Not
+}
@@ -0,0 +1,100 @@
+package codesearchpatch
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// spaceClass matches whitespace: ASCII whitespace, with newlines, and the
+// Unicode spaces, like the no-break spaces of text copied from web pages.
+const spaceClass = `[\s\p{Zs}]`
+
+// SpaceInsensitive returns a pattern matching the regexp pat with any run of
+// whitespace in it matching any run of whitespace, newlines included: code
+// indented with tabs instead of spaces, or wrapped at other places. Leading
+// and trailing whitespace in pat is dropped. pat must not have whitespace in
+// character classes or escapes, like [ ,] or \ . Searches for it need
+// Grep.Multiline to match across lines.
+func SpaceInsensitive(pat string) string {
+	return strings.Join(strings.Fields(pat), spaceClass+"+")
+}
+
+// multiline searches text, the contents of the named file, for matches
+// spanning lines, for ReaderContext. The matched lines it reports are the
+// lines that the matches span; the next match starts after them.
+func (g *Grep) multiline(ctx context.Context, text []byte, name string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if g.lre == nil {
+		return fmt.Errorf("cannot search %s across lines: %s does not compile", name, g.Regexp)
+	}
+	prefix := ""
+	if !g.H {
+		prefix = name + ":"
+	}
+	var (
+		lineno   = 1
+		last     = 0 // text[last] is at the start of line lineno
+		count    = 0
+		reported = 0 // matches reported in the file
+	)
+	for _, loc := range g.findAllIndex(text, -1) {
+		if loc[0] < last {
+			// on the lines of the last match
+			continue
+		}
+		lineStart := bytes.LastIndexByte(text[:loc[0]], '\n') + 1
+		lineEnd := indexByteFrom(text, '\n', max(loc[0], loc[1]-1))
+		if lineEnd < len(text) {
+			lineEnd++
+		}
+		lineno += countNL(text[last:lineStart])
+		lines := 1 + countNL(text[lineStart:max(lineStart, lineEnd-1)])
+		last = lineEnd
+
+		near := g.nearOK(lineno)
+		skip := !near || g.FileLimit > 0 && reported >= g.FileLimit
+		switch {
+		case !near:
+			// not a match
+		case skip:
+			g.FileSkipped++
+		case g.Limit > 0 && g.Matches >= g.Limit || g.budget != nil && !g.budget.take():
+			g.Limited = true
+			return ErrLimit
+		default:
+			g.Matches++
+			reported++
+		}
+		if near {
+			g.Match = true
+		}
+		if near && g.L {
+			fmt.Fprintf(g.Stdout, "%s\n", name)
+			return nil
+		}
+		switch {
+		case skip:
+		case g.C:
+			count++
+		case g.OnMatch != nil:
+			pos := MatchPos{Start: loc[0], End: loc[1], Offset: int64(loc[0])}
+			pos.Column = 1 + utf8.RuneCount(text[lineStart:loc[0]])
+			g.OnMatch(text, name, lineno, lineStart, lineEnd, pos)
+		default:
+			// like the N output, a line at a time
+			for i, line := range splitLines(text[lineStart:lineEnd]) {
+				fmt.Fprintf(g.Stdout, "%s%d:%s\n", prefix, lineno+i, line)
+			}
+		}
+		lineno += lines
+	}
+	if g.C && count > 0 {
+		fmt.Fprintf(g.Stdout, "%s: %d\n", name, count)
+	}
+	return nil
+}
@@ -0,0 +1,17 @@
+package codesearchpatch
+
+// wordChars are the characters of words: letters, marks, digits and
+// connector punctuation like '_'. The \b of the regexp matchers knows only
+// ASCII words, so it finds "ber" in "über".
+const wordChars = `\pL\pM\pN\p{Pc}`
+
+// wordGroup is the name of the group of the word in WholeWord patterns.
+const wordGroup = "word"
+
+// WholeWord returns a pattern matching the regexp pat only as whole words:
+// not preceded or followed on its line by a word character. Grep reports
+// the word, not the characters around it, as the match. The pattern has the
+// trigrams of pat, so the index prunes files as well as for pat.
+func WholeWord(pat string) string {
+	return `(?m:^|[^` + wordChars + `])(?P<` + wordGroup + `>` + pat + `)(?m:[^` + wordChars + `]|$)`
+}
@@ -0,0 +1,307 @@
+// Package highlight implements syntax highlighting of source code as HTML.
+//
+// The lexer is deliberately simple: a language is described by its comments,
+// string delimiters and word lists (see Language), which is enough to color
+// the common languages without a grammar per language.
+package highlight
+
+import (
+	"bytes"
+	"html/template"
+	"strings"
+)
+
+// Kind is the kind of a token.
+type Kind int
+
+const (
+	Plain Kind = iota
+	Comment
+	String
+	Number
+	Keyword
+	Type
+	Builtin
+)
+
+// classes maps kinds to the CSS classes of their spans.
+var classes = [...]string{
+	Comment: "hl-comment",
+	String:  "hl-string",
+	Number:  "hl-number",
+	Keyword: "hl-keyword",
+	Type:    "hl-type",
+	Builtin: "hl-builtin",
+}
+
+// Delims are the opening and closing delimiters of a comment or string.
+type Delims struct {
+	Open      string
+	Close     string
+	Escape    bool // backslash escapes the next byte (strings only)
+	Multiline bool // may span lines (strings only; block comments always may)
+}
+
+// Language describes the lexical syntax of a language.
+type Language struct {
+	Name          string
+	LineComments  []string // line comment prefixes
+	BlockComments []Delims
+	Strings       []Delims // tried in order, so longer delimiters go first
+
+	// CharLiterals reports whether ' delimits short character literals
+	// and is plain otherwise (e.g., Rust lifetimes).
+	CharLiterals bool
+	// CommentAfterSpace reports whether line comments must be at the start
+	// of a line or after whitespace (e.g., # in shell).
+	CommentAfterSpace bool
+	// IgnoreCase reports whether words are case-insensitive (e.g., SQL).
+	IgnoreCase bool
+	// Tags reports whether the language is markup: tag names are keywords
+	// and strings are recognized only inside tags.
+	Tags bool
+	// IdentChars lists the bytes besides letters, digits and _ that are
+	// part of words.
+	IdentChars string
+
+	Keywords map[string]bool
+	Types    map[string]bool
+	Builtins map[string]bool
+}
+
+// words returns the set of the space-separated words in s.
+func words(s string) map[string]bool {
+	m := make(map[string]bool)
+	for _, w := range strings.Fields(s) {
+		m[w] = true
+	}
+	return m
+}
+
+type token struct {
+	kind Kind
+	end  int // the token is src[previous token's end:end]
+}
+
+type lexer struct {
+	lang  *Language
+	src   []byte
+	toks  []token
+	pos   int
+	inTag bool // inside a markup tag
+}
+
+func (l *lexer) emit(kind Kind, end int) {
+	if n := len(l.toks); n > 0 && l.toks[n-1].kind == kind {
+		l.toks[n-1].end = end
+	} else {
+		l.toks = append(l.toks, token{kind, end})
+	}
+	l.pos = end
+}
+
+func (l *lexer) isIdent(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		c == '_' || c >= 0x80 || strings.IndexByte(l.lang.IdentChars, c) >= 0
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func lex(lang *Language, src []byte) []token {
+	l := &lexer{lang: lang, src: src}
+	for l.pos < len(src) {
+		l.next()
+	}
+	return l.toks
+}
+
+// next lexes the token at l.pos.
+func (l *lexer) next() {
+	src, i, lang := l.src, l.pos, l.lang
+	rest := src[i:]
+	for _, d := range lang.BlockComments {
+		if bytes.HasPrefix(rest, []byte(d.Open)) {
+			end := len(src)
+			if j := bytes.Index(rest[len(d.Open):], []byte(d.Close)); j >= 0 {
+				end = i + len(d.Open) + j + len(d.Close)
+			}
+			l.emit(Comment, end)
+			return
+		}
+	}
+	if !lang.CommentAfterSpace || i == 0 || isSpace(src[i-1]) {
+		for _, p := range lang.LineComments {
+			if bytes.HasPrefix(rest, []byte(p)) {
+				end := len(src)
+				if j := bytes.IndexByte(rest, '\n'); j >= 0 {
+					end = i + j
+				}
+				l.emit(Comment, end)
+				return
+			}
+		}
+	}
+	if !lang.Tags || l.inTag {
+		for _, d := range lang.Strings {
+			if bytes.HasPrefix(rest, []byte(d.Open)) {
+				l.emit(String, l.stringEnd(i+len(d.Open), d))
+				return
+			}
+		}
+	}
+
+	c := src[i]
+	switch {
+	case lang.CharLiterals && c == '\'':
+		// look for the closing quote of a short literal like '\x00'
+		for j := i + 1; j < len(src) && j < i+12; j++ {
+			if src[j] == '\\' {
+				j++
+			} else if src[j] == '\'' && j > i+1 {
+				l.emit(String, j+1)
+				return
+			} else if src[j] == '\n' {
+				break
+			}
+		}
+		l.emit(Plain, i+1)
+	case lang.Tags && c == '<':
+		j := i + 1
+		if j < len(src) && src[j] == '/' {
+			j++
+		}
+		l.emit(Plain, j)
+		if j < len(src) && l.isIdent(src[j]) {
+			l.inTag = true
+			end := j
+			for end < len(src) && (l.isIdent(src[end]) || src[end] == ':' || src[end] == '-') {
+				end++
+			}
+			l.emit(Keyword, end)
+		}
+	case lang.Tags && c == '>':
+		l.inTag = false
+		l.emit(Plain, i+1)
+	case isDigit(c) || c == '.' && i+1 < len(src) && isDigit(src[i+1]):
+		l.emit(Number, l.numberEnd(i))
+	case l.isIdent(c):
+		end := i
+		for end < len(src) && l.isIdent(src[end]) {
+			end++
+		}
+		l.emit(l.word(string(src[i:end])), end)
+	default:
+		l.emit(Plain, i+1)
+	}
+}
+
+// stringEnd returns the end of the string whose contents start at i.
+func (l *lexer) stringEnd(i int, d Delims) int {
+	src := l.src
+	for i < len(src) {
+		switch {
+		case d.Escape && src[i] == '\\':
+			i += 2
+			continue
+		case bytes.HasPrefix(src[i:], []byte(d.Close)):
+			return i + len(d.Close)
+		case src[i] == '\n' && !d.Multiline:
+			// unterminated
+			return i
+		}
+		i++
+	}
+	return len(src)
+}
+
+// numberEnd returns the end of the number starting at i.
+func (l *lexer) numberEnd(i int) int {
+	src := l.src
+	start := i
+	hex := bytes.HasPrefix(src[i:], []byte("0x")) || bytes.HasPrefix(src[i:], []byte("0X"))
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case l.isIdent(c) || c == '.':
+		case (c == '+' || c == '-') && i > start && !hex && (src[i-1] == 'e' || src[i-1] == 'E'):
+		default:
+			return i
+		}
+		i++
+	}
+	return i
+}
+
+func (l *lexer) word(w string) Kind {
+	if l.lang.IgnoreCase {
+		w = strings.ToLower(w)
+	}
+	switch {
+	case l.lang.Keywords[w]:
+		return Keyword
+	case l.lang.Types[w]:
+		return Type
+	case l.lang.Builtins[w]:
+		return Builtin
+	}
+	return Plain
+}
+
+// Lines highlights src as lang and returns the HTML of each line, without
+// the newline. Tokens spanning lines are split so that each line is
+// well-formed. A nil lang escapes src without highlighting.
+//
+// A final newline does not start another line.
+func Lines(lang *Language, src []byte) []template.HTML {
+	var toks []token
+	if lang != nil {
+		toks = lex(lang, src)
+	} else if len(src) > 0 {
+		toks = []token{{Plain, len(src)}}
+	}
+
+	var (
+		lines []template.HTML
+		b     strings.Builder
+		start int
+	)
+	for _, t := range toks {
+		text := src[start:t.end]
+		start = t.end
+		for {
+			seg, after, found := bytes.Cut(text, []byte("\n"))
+			writeSpan(&b, t.kind, seg)
+			if !found {
+				break
+			}
+			lines = append(lines, template.HTML(b.String()))
+			b.Reset()
+			text = after
+		}
+	}
+	if b.Len() > 0 || len(src) > 0 && src[len(src)-1] != '\n' {
+		lines = append(lines, template.HTML(b.String()))
+	}
+	return lines
+}
+
+func writeSpan(b *strings.Builder, kind Kind, text []byte) {
+	if len(text) == 0 {
+		return
+	}
+	if kind == Plain {
+		template.HTMLEscape(b, text)
+		return
+	}
+	b.WriteString(`<span class="`)
+	b.WriteString(classes[kind])
+	b.WriteString(`">`)
+	template.HTMLEscape(b, text)
+	b.WriteString(`</span>`)
+}
@@ -0,0 +1,306 @@
+package highlight
+
+import (
+	"path"
+	"strings"
+)
+
+var (
+	cStyleComments = []string{"//"}
+	cBlockComments = []Delims{{Open: "/*", Close: "*/"}}
+	hashComments   = []string{"#"}
+
+	dqString  = Delims{Open: `"`, Close: `"`, Escape: true}
+	sqString  = Delims{Open: `'`, Close: `'`, Escape: true}
+	btString  = Delims{Open: "`", Close: "`", Multiline: true}
+	cStrings  = []Delims{dqString}
+	jsStrings = []Delims{dqString, sqString, {Open: "`", Close: "`", Escape: true, Multiline: true}}
+)
+
+var goLang = &Language{
+	Name:          "go",
+	LineComments:  cStyleComments,
+	BlockComments: cBlockComments,
+	Strings:       []Delims{dqString, btString},
+	CharLiterals:  true,
+	Keywords:      words("break case chan const continue default defer else fallthrough for func go goto if import interface map package range return select struct switch type var"),
+	Types:         words("any bool byte comparable complex64 complex128 error float32 float64 int int8 int16 int32 int64 rune string uint uint8 uint16 uint32 uint64 uintptr"),
+	Builtins:      words("append cap clear close complex copy delete false imag iota len make max min new nil panic print println real recover true"),
+}
+
+var cLang = &Language{
+	Name:          "c",
+	LineComments:  cStyleComments,
+	BlockComments: cBlockComments,
+	Strings:       cStrings,
+	CharLiterals:  true,
+	IdentChars:    "#",
+	Keywords:      words("auto break case const continue default do else enum extern for goto if inline register restrict return sizeof static struct switch typedef union volatile while #include #define #undef #if #ifdef #ifndef #else #elif #endif #pragma #error"),
+	Types:         words("bool char double float int long short signed unsigned void size_t ssize_t int8_t int16_t int32_t int64_t uint8_t uint16_t uint32_t uint64_t uintptr_t FILE"),
+	Builtins:      words("NULL true false"),
+}
+
+var cppLang = &Language{
+	Name:          "cpp",
+	LineComments:  cStyleComments,
+	BlockComments: cBlockComments,
+	Strings:       cStrings,
+	CharLiterals:  true,
+	IdentChars:    "#",
+	Keywords:      words("alignas alignof auto break case catch class const constexpr const_cast continue decltype default delete do dynamic_cast else enum explicit export extern for friend goto if inline mutable namespace new noexcept operator private protected public reinterpret_cast return sizeof static static_assert static_cast struct switch template this throw try typedef typeid typename union using virtual volatile while #include #define #undef #if #ifdef #ifndef #else #elif #endif #pragma #error"),
+	Types:         words("bool char char16_t char32_t double float int long short signed unsigned void wchar_t size_t string vector map"),
+	Builtins:      words("nullptr NULL true false std"),
+}
+
+var javaLang = &Language{
+	Name:          "java",
+	LineComments:  cStyleComments,
+	BlockComments: cBlockComments,
+	Strings:       []Delims{{Open: `"""`, Close: `"""`, Escape: true, Multiline: true}, dqString},
+	CharLiterals:  true,
+	IdentChars:    "@",
+	Keywords:      words("abstract assert break case catch class const continue default do else enum extends final finally for goto if implements import instanceof interface native new package private protected public record return static strictfp super switch synchronized this throw throws transient try var void volatile while yield"),
+	Types:         words("boolean byte char double float int long short String Object Integer Long Boolean List Map"),
+	Builtins:      words("true false null"),
+}
+
+var csharpLang = &Language{
+	Name:          "csharp",
+	LineComments:  cStyleComments,
+	BlockComments: cBlockComments,
+	Strings:       []Delims{{Open: `@"`, Close: `"`, Multiline: true}, dqString},
+	CharLiterals:  true,
+	IdentChars:    "#",
+	Keywords:      words("abstract as async await base break case catch checked class const continue default delegate do else enum event explicit extern finally fixed for foreach goto if implicit in interface internal is lock namespace new operator out override params private protected public readonly ref return sealed sizeof stackalloc static struct switch this throw try typeof unchecked unsafe using var virtual void volatile while yield #if #else #elif #endif #region #endregion"),
+	Types:         words("bool byte char decimal double float int long object sbyte short string uint ulong ushort dynamic"),
+	Builtins:      words("true false null"),
+}
+
+var jsLang = &Language{
+	Name:          "javascript",
+	LineComments:  cStyleComments,
+	BlockComments: cBlockComments,
+	Strings:       jsStrings,
+	IdentChars:    "$",
+	Keywords:      words("async await break case catch class const continue debugger default delete do else export extends finally for from function if import in instanceof let new of return static super switch this throw try typeof var void while with yield"),
+	Builtins:      words("true false null undefined NaN Infinity console document window"),
+}
+
+var tsLang = &Language{
+	Name:          "typescript",
+	LineComments:  cStyleComments,
+	BlockComments: cBlockComments,
+	Strings:       jsStrings,
+	IdentChars:    "$",
+	Keywords:      words("abstract as async await break case catch class const continue debugger declare default delete do else enum export extends finally for from function if implements import in instanceof interface keyof let namespace new of private protected public readonly return static super switch this throw try type typeof var void while with yield"),
+	Types:         words("any bigint boolean never number object string symbol unknown"),
+	Builtins:      words("true false null undefined NaN Infinity console document window"),
+}
+
+var pythonLang = &Language{
+	Name:         "python",
+	LineComments: hashComments,
+	Strings: []Delims{
+		{Open: `"""`, Close: `"""`, Escape: true, Multiline: true},
+		{Open: `'''`, Close: `'''`, Escape: true, Multiline: true},
+		dqString,
+		sqString,
+	},
+	IdentChars: "@",
+	Keywords:   words("and as assert async await break class continue def del elif else except finally for from global if import in is lambda nonlocal not or pass raise return try while with yield match case"),
+	Types:      words("bool bytes dict float frozenset int list object set str tuple"),
+	Builtins:   words("True False None self cls print len range enumerate zip map filter open isinstance super"),
+}
+
+var rubyLang = &Language{
+	Name:          "ruby",
+	LineComments:  hashComments,
+	BlockComments: []Delims{{Open: "=begin", Close: "=end"}},
+	Strings:       []Delims{dqString, sqString},
+	IdentChars:    "?!@",
+	Keywords:      words("alias and begin break case class def defined? do else elsif end ensure for if in module next not or redo rescue retry return self super then undef unless until when while yield require attr_accessor attr_reader"),
+	Builtins:      words("true false nil puts"),
+}
+
+var rustLang = &Language{
+	Name:          "rust",
+	LineComments:  cStyleComments,
+	BlockComments: cBlockComments,
+	Strings:       []Delims{dqString},
+	CharLiterals:  true,
+	IdentChars:    "!",
+	Keywords:      words("as async await break const continue crate dyn else enum extern fn for if impl in let loop match mod move mut pub ref return self Self static struct super trait type unsafe use where while"),
+	Types:         words("bool char f32 f64 i8 i16 i32 i64 i128 isize str u8 u16 u32 u64 u128 usize String Vec Option Result Box"),
+	Builtins:      words("true false None Some Ok Err println! print! format! vec! panic! assert! assert_eq!"),
+}
+
+var shellLang = &Language{
+	Name:              "shell",
+	LineComments:      hashComments,
+	Strings:           []Delims{{Open: `"`, Close: `"`, Escape: true, Multiline: true}, {Open: `'`, Close: `'`, Multiline: true}},
+	CommentAfterSpace: true,
+	IdentChars:        "-",
+	Keywords:          words("case do done elif else esac fi for function if in select then until while"),
+	Builtins:          words("alias cd echo eval exec exit export local printf read return set shift source test trap unset"),
+}
+
+var sqlLang = &Language{
+	Name:          "sql",
+	LineComments:  []string{"--"},
+	BlockComments: cBlockComments,
+	Strings:       []Delims{{Open: `'`, Close: `'`}, {Open: `"`, Close: `"`}},
+	IgnoreCase:    true,
+	Keywords:      words("add all alter and as asc begin between by case check column commit constraint create cross database default delete desc distinct drop else end exists foreign from full group having if in index inner insert into is join key left like limit not null offset on or order outer primary references replace returning right rollback select set table then transaction union unique update using values view when where with"),
+	Types:         words("bigint blob boolean char date datetime decimal double float int integer json numeric real serial smallint text time timestamp uuid varchar"),
+	Builtins:      words("avg coalesce count max min now sum true false"),
+}
+
+var yamlLang = &Language{
+	Name:              "yaml",
+	LineComments:      hashComments,
+	Strings:           []Delims{dqString, {Open: `'`, Close: `'`}},
+	CommentAfterSpace: true,
+	Builtins:          words("true false null yes no on off"),
+}
+
+var jsonLang = &Language{
+	Name:     "json",
+	Strings:  []Delims{dqString},
+	Builtins: words("true false null"),
+}
+
+var tomlLang = &Language{
+	Name:         "toml",
+	LineComments: []string{"#", ";"},
+	Strings: []Delims{
+		{Open: `"""`, Close: `"""`, Escape: true, Multiline: true},
+		{Open: `'''`, Close: `'''`, Multiline: true},
+		dqString,
+		{Open: `'`, Close: `'`},
+	},
+	Builtins: words("true false"),
+}
+
+var markupLang = &Language{
+	Name:          "markup",
+	BlockComments: []Delims{{Open: "<!--", Close: "-->"}},
+	Strings:       []Delims{{Open: `"`, Close: `"`, Multiline: true}, {Open: `'`, Close: `'`, Multiline: true}},
+	Tags:          true,
+}
+
+var cssLang = &Language{
+	Name:          "css",
+	LineComments:  cStyleComments, // scss
+	BlockComments: cBlockComments,
+	Strings:       []Delims{dqString, sqString},
+	IdentChars:    "-@",
+	Keywords:      words("@media @import @font-face @keyframes @supports !important"),
+}
+
+var makeLang = &Language{
+	Name:              "make",
+	LineComments:      hashComments,
+	CommentAfterSpace: true,
+	Keywords:          words("ifeq ifneq ifdef ifndef else endif include define endef export override .PHONY"),
+}
+
+var dockerLang = &Language{
+	Name:              "dockerfile",
+	LineComments:      hashComments,
+	Strings:           []Delims{dqString, {Open: `'`, Close: `'`}},
+	CommentAfterSpace: true,
+	IgnoreCase:        true,
+	Keywords:          words("add arg cmd copy entrypoint env expose from healthcheck label maintainer onbuild run shell stopsignal user volume workdir as"),
+}
+
+var byExt = map[string]*Language{
+	".go":   goLang,
+	".c":    cLang,
+	".h":    cLang,
+	".cc":   cppLang,
+	".cpp":  cppLang,
+	".cxx":  cppLang,
+	".hh":   cppLang,
+	".hpp":  cppLang,
+	".java": javaLang,
+	".cs":   csharpLang,
+	".js":   jsLang,
+	".mjs":  jsLang,
+	".cjs":  jsLang,
+	".jsx":  jsLang,
+	".ts":   tsLang,
+	".tsx":  tsLang,
+	".py":   pythonLang,
+	".pyi":  pythonLang,
+	".rb":   rubyLang,
+	".rs":   rustLang,
+	".sh":   shellLang,
+	".bash": shellLang,
+	".zsh":  shellLang,
+	".sql":  sqlLang,
+	".yaml": yamlLang,
+	".yml":  yamlLang,
+	".json": jsonLang,
+	".toml": tomlLang,
+	".ini":  tomlLang,
+	".cfg":  tomlLang,
+	".html": markupLang,
+	".htm":  markupLang,
+	".xml":  markupLang,
+	".svg":  markupLang,
+	".css":  cssLang,
+	".scss": cssLang,
+	".mk":   makeLang,
+}
+
+var byName = map[string]*Language{
+	"Makefile":    makeLang,
+	"GNUmakefile": makeLang,
+	"Dockerfile":  dockerLang,
+	".bashrc":     shellLang,
+	".zshrc":      shellLang,
+	".profile":    shellLang,
+}
+
+// Detect returns the language of the named file based on its name and
+// extension, or nil if the language is not known.
+func Detect(name string) *Language {
+	base := path.Base(name)
+	if lang := byName[base]; lang != nil {
+		return lang
+	}
+	if strings.HasPrefix(base, "Dockerfile.") {
+		return dockerLang
+	}
+	return byExt[strings.ToLower(path.Ext(base))]
+}
+
+// byAlias maps language names that are not also file extensions.
+var byAlias = map[string]*Language{
+	"golang":     goLang,
+	"c++":        cppLang,
+	"java":       javaLang,
+	"csharp":     csharpLang,
+	"c#":         csharpLang,
+	"javascript": jsLang,
+	"typescript": tsLang,
+	"python":     pythonLang,
+	"ruby":       rubyLang,
+	"rust":       rustLang,
+	"shell":      shellLang,
+	"console":    shellLang,
+	"dockerfile": dockerLang,
+	"makefile":   makeLang,
+	"make":       makeLang,
+}
+
+// Lookup returns the language with the given name or file extension, as
+// used in the info string of Markdown code fences, or nil if the language
+// is not known.
+func Lookup(name string) *Language {
+	name = strings.ToLower(name)
+	if lang := byAlias[name]; lang != nil {
+		return lang
+	}
+	return byExt["."+name]
+}
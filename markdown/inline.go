@@ -0,0 +1,397 @@
+package markdown
+
+import (
+	"html"
+	"html/template"
+	"strings"
+)
+
+func isPunct(c byte) bool {
+	return strings.IndexByte("!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~", c) >= 0
+}
+
+func isSpaceByte(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n'
+}
+
+func isAlnum(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9'
+}
+
+// inline renders the inline content s.
+func (r *renderer) inline(s string) {
+	w := &r.b
+	text := 0 // start of pending plain text
+	flush := func(i int) {
+		template.HTMLEscape(w, []byte(s[text:i]))
+	}
+	for i := 0; i < len(s); {
+		c := s[i]
+		var n int // length of the markup at i, or 0
+		switch {
+		case c == '\\' && i+1 < len(s) && s[i+1] == '\n':
+			flush(i)
+			w.WriteString("<br>\n")
+			n = 2
+		case c == '\\' && i+1 < len(s) && isPunct(s[i+1]):
+			flush(i)
+			template.HTMLEscape(w, []byte{s[i+1]})
+			n = 2
+		case c == '`':
+			flush(i)
+			n = r.codeSpan(s[i:])
+		case c == '!' && i+1 < len(s) && s[i+1] == '[':
+			flush(i)
+			n = r.link(s[i:], true)
+		case c == '[':
+			flush(i)
+			n = r.link(s[i:], false)
+		case c == '<':
+			flush(i)
+			n = r.autolink(s[i:])
+		case c == '_' && i > 0 && isAlnum(s[i-1]):
+			// intraword underscore
+			i++
+			continue
+		case c == '*' || c == '_' || c == '~':
+			flush(i)
+			n = r.emphasis(s[i:])
+		case c == 'h' && (i == 0 || !isAlnum(s[i-1])) && (strings.HasPrefix(s[i:], "http://") || strings.HasPrefix(s[i:], "https://")):
+			flush(i)
+			n = r.bareURL(s[i:])
+		default:
+			i++
+			continue
+		}
+		if n == 0 {
+			// not markup; write the character as text
+			template.HTMLEscape(w, []byte{c})
+			n = 1
+		}
+		i += n
+		text = i
+	}
+	flush(len(s))
+}
+
+// codeSpan renders the code span at the start of s and returns its length,
+// or 0 if there is none. A backtick run without a closing run is literal.
+func (r *renderer) codeSpan(s string) int {
+	n := len(s) - len(strings.TrimLeft(s, "`"))
+	open := s[:n]
+	for i := n; i < len(s); {
+		j := strings.Index(s[i:], open)
+		if j < 0 {
+			break
+		}
+		j += i
+		m := len(s[j:]) - len(strings.TrimLeft(s[j:], "`"))
+		if m != n {
+			i = j + m
+			continue
+		}
+		code := strings.ReplaceAll(s[n:j], "\n", " ")
+		if len(code) >= 2 && code[0] == ' ' && code[len(code)-1] == ' ' && strings.Trim(code, " ") != "" {
+			code = code[1 : len(code)-1]
+		}
+		r.b.WriteString("<code>")
+		template.HTMLEscape(&r.b, []byte(code))
+		r.b.WriteString("</code>")
+		return j + n
+	}
+	// literal backticks
+	r.b.WriteString(open)
+	return n
+}
+
+// link renders the link (or image) at the start of s and returns its
+// length, or 0 if there is none.
+func (r *renderer) link(s string, image bool) int {
+	start := 1
+	if image {
+		start = 2
+	}
+	// find the closing bracket, skipping nested brackets and code spans
+	depth, end := 0, -1
+loop:
+	for i := start; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '`':
+			if j := strings.IndexByte(s[i+1:], '`'); j >= 0 {
+				i += j + 1
+			}
+		case '[':
+			depth++
+		case ']':
+			if depth == 0 {
+				end = i
+				break loop
+			}
+			depth--
+		}
+	}
+	if end < 0 {
+		return 0
+	}
+	text := s[start:end]
+	rest := s[end+1:]
+
+	var dest, title string
+	n := end + 1
+	switch {
+	case strings.HasPrefix(rest, "("):
+		d, t, m, ok := parseInlineDest(rest)
+		if !ok {
+			return 0
+		}
+		dest, title = d, t
+		n += m
+	default:
+		label := text
+		if strings.HasPrefix(rest, "[") {
+			if j := strings.IndexByte(rest, ']'); j >= 0 {
+				if l := rest[1:j]; l != "" {
+					label = l
+				}
+				n += j + 1
+			}
+		}
+		ref, ok := r.refs[normalizeLabel(label)]
+		if !ok {
+			return 0
+		}
+		dest, title = ref.dest, ref.title
+	}
+
+	w := &r.b
+	if image {
+		src := safeURL(dest)
+		if r.Image != nil {
+			src = r.Image(src)
+		}
+		w.WriteString(`<img src="`)
+		w.WriteString(template.HTMLEscapeString(src))
+		w.WriteString(`" alt="`)
+		w.WriteString(template.HTMLEscapeString(r.plainText(text)))
+		w.WriteString(`"`)
+		if title != "" {
+			w.WriteString(` title="` + template.HTMLEscapeString(title) + `"`)
+		}
+		w.WriteString(">")
+		return n
+	}
+	r.openLink(dest, title)
+	r.inline(text)
+	w.WriteString("</a>")
+	return n
+}
+
+func (r *renderer) openLink(dest, title string) {
+	href := safeURL(dest)
+	if r.Link != nil {
+		href = r.Link(href)
+	}
+	w := &r.b
+	w.WriteString(`<a href="`)
+	w.WriteString(template.HTMLEscapeString(href))
+	w.WriteString(`"`)
+	if title != "" {
+		w.WriteString(` title="` + template.HTMLEscapeString(title) + `"`)
+	}
+	w.WriteString(">")
+}
+
+// parseInlineDest parses the destination and optional title in
+// parentheses at the start of s, like (dest "title"), and returns the
+// length of the parsed text.
+func parseInlineDest(s string) (dest, title string, n int, ok bool) {
+	i := 1
+	for i < len(s) && isSpaceByte(s[i]) {
+		i++
+	}
+	if i < len(s) && s[i] == '<' {
+		j := strings.IndexByte(s[i:], '>')
+		if j < 0 {
+			return "", "", 0, false
+		}
+		dest = s[i+1 : i+j]
+		i += j + 1
+	} else {
+		start, depth := i, 0
+		for ; i < len(s) && !isSpaceByte(s[i]); i++ {
+			if s[i] == '\\' {
+				i++
+			} else if s[i] == '(' {
+				depth++
+			} else if s[i] == ')' {
+				if depth == 0 {
+					break
+				}
+				depth--
+			}
+		}
+		if i > len(s) {
+			i = len(s)
+		}
+		dest = s[start:i]
+	}
+	for i < len(s) && isSpaceByte(s[i]) {
+		i++
+	}
+	if i < len(s) && (s[i] == '"' || s[i] == '\'' || s[i] == '(') {
+		closing := s[i]
+		if closing == '(' {
+			closing = ')'
+		}
+		j := strings.IndexByte(s[i+1:], closing)
+		if j < 0 {
+			return "", "", 0, false
+		}
+		title = s[i+1 : i+1+j]
+		i += j + 2
+		for i < len(s) && isSpaceByte(s[i]) {
+			i++
+		}
+	}
+	if i >= len(s) || s[i] != ')' {
+		return "", "", 0, false
+	}
+	return unescape(dest), unescape(title), i + 1, true
+}
+
+// unescape removes backslash escapes from s.
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && isPunct(s[i+1]) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// autolink renders the autolink at the start of s, like <https://go.dev>,
+// and returns its length, or 0 if there is none.
+func (r *renderer) autolink(s string) int {
+	end := strings.IndexAny(s[1:], "<> \n") + 1
+	if end <= 1 || s[end] != '>' {
+		return 0
+	}
+	u := s[1:end]
+	switch {
+	case strings.Contains(u, "@") && !strings.Contains(u, ":"):
+		r.openLink("mailto:"+u, "")
+	case strings.Contains(u, ":"):
+		r.openLink(u, "")
+	default:
+		return 0
+	}
+	template.HTMLEscape(&r.b, []byte(u))
+	r.b.WriteString("</a>")
+	return end + 1
+}
+
+// bareURL renders the URL at the start of s as a link and returns its
+// length.
+func (r *renderer) bareURL(s string) int {
+	end := strings.IndexAny(s, " \t\n<")
+	if end < 0 {
+		end = len(s)
+	}
+	// trailing punctuation is not part of the URL
+	for end > 0 && strings.IndexByte(".,:;!?\"')*_~", s[end-1]) >= 0 {
+		if s[end-1] == ')' && strings.Count(s[:end], "(") >= strings.Count(s[:end], ")") {
+			break
+		}
+		end--
+	}
+	u := s[:end]
+	r.openLink(u, "")
+	template.HTMLEscape(&r.b, []byte(u))
+	r.b.WriteString("</a>")
+	return end
+}
+
+// emphasis renders the emphasis (or strikethrough) at the start of s and
+// returns its length, or 0 if there is none.
+func (r *renderer) emphasis(s string) int {
+	c := s[0]
+	n := len(s) - len(strings.TrimLeft(s, string(c)))
+	if n > 3 || c == '~' && n != 2 {
+		return 0
+	}
+	if n >= len(s) || isSpaceByte(s[n]) {
+		// not left-flanking
+		return 0
+	}
+	delim := s[:n]
+	for i := n; i < len(s); {
+		j := strings.Index(s[i:], delim)
+		if j < 0 {
+			return 0
+		}
+		j += i
+		m := len(s[j:]) - len(strings.TrimLeft(s[j:], string(c)))
+		rightFlanking := !isSpaceByte(s[j-1])
+		if c == '_' && j+m < len(s) && isAlnum(s[j+m]) {
+			rightFlanking = false
+		}
+		if m != n || !rightFlanking || s[j-1] == '\\' {
+			i = j + m
+			continue
+		}
+		open, close := "<em>", "</em>"
+		switch {
+		case c == '~':
+			open, close = "<del>", "</del>"
+		case n == 2:
+			open, close = "<strong>", "</strong>"
+		case n == 3:
+			open, close = "<em><strong>", "</strong></em>"
+		}
+		r.b.WriteString(open)
+		r.inline(s[n:j])
+		r.b.WriteString(close)
+		return j + n
+	}
+	return 0
+}
+
+// safeURL returns u unless it has a scheme other than http, https, mailto
+// or ftp (e.g., javascript:), in which case it returns "#".
+func safeURL(u string) string {
+	i := strings.IndexAny(u, ":/?#")
+	if i < 0 || u[i] != ':' {
+		return u // relative
+	}
+	switch strings.ToLower(u[:i]) {
+	case "http", "https", "mailto", "ftp":
+		return u
+	}
+	return "#"
+}
+
+// plainText returns the text of the inline content s without markup.
+func (r *renderer) plainText(s string) string {
+	rr := &renderer{Renderer: &Renderer{}, refs: r.refs}
+	rr.inline(s)
+	var b strings.Builder
+	inTag := false
+	for _, c := range rr.b.String() {
+		switch {
+		case c == '<':
+			inTag = true
+		case c == '>':
+			inTag = false
+		case !inTag:
+			b.WriteRune(c)
+		}
+	}
+	return html.UnescapeString(b.String())
+}
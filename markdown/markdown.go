@@ -0,0 +1,627 @@
+// Package markdown renders Markdown documents as HTML.
+//
+// It implements the commonly used subset of CommonMark and GitHub Flavored
+// Markdown: headings, paragraphs, emphasis, code spans and blocks, block
+// quotes, nested lists, thematic breaks, links (inline, reference and
+// automatic), images and tables. Raw HTML is escaped, not passed through, so
+// the output is safe to embed in a page.
+package markdown
+
+import (
+	"fmt"
+	"html/template"
+	"strings"
+)
+
+// Renderer renders Markdown documents. The zero value is ready to use.
+type Renderer struct {
+	// Link rewrites link destinations, if non-nil.
+	Link func(dest string) string
+	// Image rewrites image sources, if non-nil.
+	Image func(src string) string
+	// Code highlights the code of fenced blocks, if non-nil. The info is
+	// the first word of the fence's info string (usually the language).
+	Code func(info, code string) template.HTML
+}
+
+type blockKind int
+
+const (
+	paragraph blockKind = iota
+	heading
+	codeBlock
+	thematicBreak
+	blockQuote
+	list
+	listItem
+	table
+)
+
+type block struct {
+	kind     blockKind
+	level    int    // heading level
+	text     string // inline text of paragraphs and headings; code of code blocks
+	info     string // code block info
+	children []*block
+
+	ordered bool
+	start   int  // first number of ordered lists
+	tight   bool // list items' paragraphs are not wrapped in <p>
+
+	align []string   // table column alignment
+	rows  [][]string // table rows; the first is the header
+}
+
+type linkRef struct {
+	dest  string
+	title string
+}
+
+type renderer struct {
+	*Renderer
+	refs map[string]linkRef
+	ids  map[string]int // heading ids in use
+	b    strings.Builder
+}
+
+// Render converts the Markdown document src to HTML.
+func (r *Renderer) Render(src []byte) template.HTML {
+	rr := &renderer{
+		Renderer: r,
+		refs:     make(map[string]linkRef),
+		ids:      make(map[string]int),
+	}
+	lines := strings.Split(strings.ReplaceAll(string(src), "\r\n", "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = expandTabs(line)
+	}
+	blocks := rr.parseBlocks(lines)
+	rr.renderBlocks(blocks, false)
+	return template.HTML(rr.b.String())
+}
+
+// expandTabs replaces the tabs in the indentation of line with spaces.
+func expandTabs(line string) string {
+	var b strings.Builder
+	col := 0
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case ' ':
+			b.WriteByte(' ')
+			col++
+		case '\t':
+			n := 4 - col%4
+			b.WriteString(strings.Repeat(" ", n))
+			col += n
+		default:
+			b.WriteString(line[i:])
+			return b.String()
+		}
+	}
+	return b.String()
+}
+
+func isBlank(line string) bool {
+	return strings.TrimSpace(line) == ""
+}
+
+func indentOf(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+func (r *renderer) parseBlocks(lines []string) []*block {
+	var blocks []*block
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		if isBlank(line) {
+			i++
+			continue
+		}
+		var b *block
+		switch {
+		case indentOf(line) >= 4:
+			b, i = parseIndentedCode(lines, i)
+		case isFence(line):
+			b, i = parseFencedCode(lines, i)
+		case isATXHeading(line):
+			b = parseATXHeading(line)
+			i++
+		case isThematicBreak(line):
+			b = &block{kind: thematicBreak}
+			i++
+		case isBlockQuote(line):
+			b, i = r.parseBlockQuote(lines, i)
+		case listMarkerOf(line) != nil:
+			b, i = r.parseList(lines, i)
+		case i+1 < len(lines) && strings.Contains(line, "|") && isDelimiterRow(lines[i+1]):
+			b, i = parseTable(lines, i)
+		default:
+			b, i = r.parseParagraph(lines, i)
+		}
+		if b != nil {
+			blocks = append(blocks, b)
+		}
+	}
+	return blocks
+}
+
+// interrupts reports whether line starts a block that ends a paragraph.
+func interrupts(line string) bool {
+	if isFence(line) || isATXHeading(line) || isThematicBreak(line) || isBlockQuote(line) {
+		return true
+	}
+	if m := listMarkerOf(line); m != nil && m.content != "" {
+		// only ordered lists starting with 1 interrupt paragraphs
+		return !m.ordered || m.start == 1
+	}
+	return false
+}
+
+func parseIndentedCode(lines []string, i int) (*block, int) {
+	var code []string
+	for ; i < len(lines); i++ {
+		if isBlank(lines[i]) {
+			code = append(code, "")
+			continue
+		}
+		if indentOf(lines[i]) < 4 {
+			break
+		}
+		code = append(code, lines[i][4:])
+	}
+	for len(code) > 0 && code[len(code)-1] == "" {
+		code = code[:len(code)-1]
+	}
+	return &block{kind: codeBlock, text: strings.Join(code, "\n") + "\n"}, i
+}
+
+func fenceOf(line string) string {
+	if indentOf(line) > 3 {
+		return ""
+	}
+	s := strings.TrimLeft(line, " ")
+	for _, c := range []string{"`", "~"} {
+		n := len(s) - len(strings.TrimLeft(s, c))
+		if n >= 3 {
+			if c == "`" && strings.Contains(s[n:], "`") {
+				return ""
+			}
+			return s[:n]
+		}
+	}
+	return ""
+}
+
+func isFence(line string) bool {
+	return fenceOf(line) != ""
+}
+
+func parseFencedCode(lines []string, i int) (*block, int) {
+	indent := indentOf(lines[i])
+	s := strings.TrimLeft(lines[i], " ")
+	fence := fenceOf(lines[i])
+	info := strings.TrimSpace(s[len(fence):])
+	if f := strings.Fields(info); len(f) > 0 {
+		info = f[0]
+	}
+	var code []string
+	for i++; i < len(lines); i++ {
+		line := lines[i]
+		if f := fenceOf(line); f != "" && f[0] == fence[0] && len(f) >= len(fence) && isBlank(strings.TrimLeft(line, " ")[len(f):]) {
+			i++
+			break
+		}
+		// remove up to the fence's indentation
+		n := min(indent, indentOf(line))
+		code = append(code, line[n:])
+	}
+	text := strings.Join(code, "\n")
+	if len(code) > 0 {
+		text += "\n"
+	}
+	return &block{kind: codeBlock, text: text, info: info}, i
+}
+
+func isATXHeading(line string) bool {
+	if indentOf(line) > 3 {
+		return false
+	}
+	s := strings.TrimLeft(line, " ")
+	n := len(s) - len(strings.TrimLeft(s, "#"))
+	return 1 <= n && n <= 6 && (len(s) == n || s[n] == ' ')
+}
+
+func parseATXHeading(line string) *block {
+	s := strings.TrimLeft(line, " ")
+	n := len(s) - len(strings.TrimLeft(s, "#"))
+	text := strings.TrimSpace(s[n:])
+	// remove the optional closing sequence
+	if t := strings.TrimRight(text, "#"); t == "" || strings.HasSuffix(t, " ") {
+		text = strings.TrimSpace(t)
+	}
+	return &block{kind: heading, level: n, text: text}
+}
+
+func isThematicBreak(line string) bool {
+	if indentOf(line) > 3 {
+		return false
+	}
+	s := strings.ReplaceAll(strings.TrimSpace(line), " ", "")
+	if len(s) < 3 {
+		return false
+	}
+	for _, c := range []string{"-", "*", "_"} {
+		if strings.Count(s, c) == len(s) {
+			return true
+		}
+	}
+	return false
+}
+
+func isBlockQuote(line string) bool {
+	return indentOf(line) <= 3 && strings.HasPrefix(strings.TrimLeft(line, " "), ">")
+}
+
+func (r *renderer) parseBlockQuote(lines []string, i int) (*block, int) {
+	var inner []string
+	for ; i < len(lines) && isBlockQuote(lines[i]); i++ {
+		s := strings.TrimLeft(lines[i], " ")[1:]
+		s = strings.TrimPrefix(s, " ")
+		inner = append(inner, s)
+	}
+	return &block{kind: blockQuote, children: r.parseBlocks(inner)}, i
+}
+
+type listMarker struct {
+	ordered bool
+	start   int
+	delim   byte // bullet character or ordered list delimiter
+	indent  int  // of the content
+	content string
+}
+
+func listMarkerOf(line string) *listMarker {
+	n := indentOf(line)
+	if n > 3 {
+		return nil
+	}
+	s := line[n:]
+	m := &listMarker{}
+	var width int
+	switch {
+	case s != "" && (s[0] == '-' || s[0] == '*' || s[0] == '+'):
+		m.delim = s[0]
+		width = 1
+	default:
+		j := 0
+		for j < len(s) && j < 9 && '0' <= s[j] && s[j] <= '9' {
+			m.start = m.start*10 + int(s[j]-'0')
+			j++
+		}
+		if j == 0 || j >= len(s) || s[j] != '.' && s[j] != ')' {
+			return nil
+		}
+		m.ordered = true
+		m.delim = s[j]
+		width = j + 1
+	}
+	rest := s[width:]
+	if rest != "" && rest[0] != ' ' {
+		return nil
+	}
+	spaces := indentOf(rest)
+	if spaces > 4 || spaces == len(rest) {
+		// code block in the item or empty item
+		spaces = 1
+	}
+	m.indent = n + width + spaces
+	if len(rest) > spaces {
+		m.content = rest[spaces:]
+	}
+	return m
+}
+
+func (r *renderer) parseList(lines []string, i int) (*block, int) {
+	first := listMarkerOf(lines[i])
+	// nextItem reports whether line starts another item of the list.
+	nextItem := func(line string) *listMarker {
+		m := listMarkerOf(line)
+		if m == nil || m.ordered != first.ordered || m.delim != first.delim {
+			return nil
+		}
+		return m
+	}
+	l := &block{kind: list, ordered: first.ordered, start: first.start, tight: true}
+	for i < len(lines) {
+		m := nextItem(lines[i])
+		if m == nil {
+			break
+		}
+		item := []string{m.content}
+		blank := false // previous line is blank
+		for i++; i < len(lines); i++ {
+			line := lines[i]
+			switch {
+			case isBlank(line):
+				item = append(item, "")
+				blank = true
+				continue
+			case indentOf(line) >= m.indent:
+				item = append(item, line[m.indent:])
+			case !blank && !interrupts(line) && listMarkerOf(line) == nil:
+				// lazy continuation of a paragraph
+				item = append(item, line)
+			default:
+				goto end
+			}
+			blank = false
+		}
+	end:
+		for len(item) > 0 && item[len(item)-1] == "" {
+			item = item[:len(item)-1]
+		}
+		children := r.parseBlocks(item)
+		more := i < len(lines) && nextItem(lines[i]) != nil
+		if blank && more || len(children) > 1 && containsBlank(item) {
+			l.tight = false
+		}
+		l.children = append(l.children, &block{kind: listItem, children: children})
+		if !more {
+			break
+		}
+	}
+	return l, i
+}
+
+func containsBlank(lines []string) bool {
+	for _, line := range lines {
+		if line == "" {
+			return true
+		}
+	}
+	return false
+}
+
+func isDelimiterRow(line string) bool {
+	cells := splitRow(line)
+	if len(cells) == 0 {
+		return false
+	}
+	for _, c := range cells {
+		c = strings.TrimSuffix(strings.TrimPrefix(c, ":"), ":")
+		if c == "" || strings.Trim(c, "-") != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// splitRow splits a table row into trimmed cells.
+func splitRow(line string) []string {
+	s := strings.TrimSpace(line)
+	s = strings.TrimPrefix(s, "|")
+	if strings.HasSuffix(s, "|") && !strings.HasSuffix(s, `\|`) {
+		s = s[:len(s)-1]
+	}
+	var cells []string
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '|':
+			cells = append(cells, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	cells = append(cells, strings.TrimSpace(s[start:]))
+	for i, c := range cells {
+		cells[i] = strings.ReplaceAll(c, `\|`, "|")
+	}
+	return cells
+}
+
+func parseTable(lines []string, i int) (*block, int) {
+	t := &block{kind: table}
+	header := splitRow(lines[i])
+	for _, c := range splitRow(lines[i+1]) {
+		switch {
+		case strings.HasPrefix(c, ":") && strings.HasSuffix(c, ":"):
+			t.align = append(t.align, "center")
+		case strings.HasSuffix(c, ":"):
+			t.align = append(t.align, "right")
+		case strings.HasPrefix(c, ":"):
+			t.align = append(t.align, "left")
+		default:
+			t.align = append(t.align, "")
+		}
+	}
+	t.rows = append(t.rows, header)
+	for i += 2; i < len(lines) && !isBlank(lines[i]) && !interrupts(lines[i]); i++ {
+		t.rows = append(t.rows, splitRow(lines[i]))
+	}
+	return t, i
+}
+
+func (r *renderer) parseParagraph(lines []string, i int) (*block, int) {
+	var text []string
+	for ; i < len(lines); i++ {
+		line := lines[i]
+		if isBlank(line) {
+			break
+		}
+		if len(text) > 0 {
+			if s := strings.TrimSpace(line); s != "" && (strings.Trim(s, "=") == "" || strings.Trim(s, "-") == "") && indentOf(line) <= 3 {
+				// setext heading
+				level := 1
+				if s[0] == '-' {
+					level = 2
+				}
+				return &block{kind: heading, level: level, text: strings.Join(text, "\n")}, i + 1
+			}
+			if interrupts(line) {
+				break
+			}
+		} else if r.parseLinkRef(line) {
+			continue
+		}
+		// two trailing spaces are a hard line break
+		s := strings.TrimLeft(line, " ")
+		if strings.HasSuffix(s, "  ") {
+			s = strings.TrimRight(s, " ") + `\`
+		} else {
+			s = strings.TrimRight(s, " ")
+		}
+		text = append(text, s)
+	}
+	if len(text) == 0 {
+		return nil, i
+	}
+	last := len(text) - 1
+	text[last] = strings.TrimSuffix(text[last], `\`)
+	return &block{kind: paragraph, text: strings.Join(text, "\n")}, i
+}
+
+// parseLinkRef records the link reference definition on line, like
+// [label]: dest "title", and reports whether it is one.
+func (r *renderer) parseLinkRef(line string) bool {
+	s := strings.TrimSpace(line)
+	if !strings.HasPrefix(s, "[") {
+		return false
+	}
+	end := strings.Index(s, "]:")
+	if end < 2 {
+		return false
+	}
+	label := s[1:end]
+	rest := strings.TrimSpace(s[end+2:])
+	dest, title, _ := strings.Cut(rest, " ")
+	if dest == "" {
+		return false
+	}
+	dest = strings.TrimSuffix(strings.TrimPrefix(dest, "<"), ">")
+	title = strings.TrimSpace(title)
+	if len(title) >= 2 {
+		title = title[1 : len(title)-1]
+	}
+	key := normalizeLabel(label)
+	if _, ok := r.refs[key]; !ok {
+		r.refs[key] = linkRef{dest: dest, title: title}
+	}
+	return true
+}
+
+func normalizeLabel(label string) string {
+	return strings.ToLower(strings.Join(strings.Fields(label), " "))
+}
+
+func (r *renderer) renderBlocks(blocks []*block, tight bool) {
+	for _, b := range blocks {
+		r.renderBlock(b, tight)
+	}
+}
+
+func (r *renderer) renderBlock(b *block, tight bool) {
+	w := &r.b
+	switch b.kind {
+	case paragraph:
+		if tight {
+			r.inline(b.text)
+			w.WriteString("\n")
+			return
+		}
+		w.WriteString("<p>")
+		r.inline(b.text)
+		w.WriteString("</p>\n")
+	case heading:
+		fmt.Fprintf(w, "<h%d id=\"%s\">", b.level, template.HTMLEscapeString(r.headingID(b.text)))
+		r.inline(b.text)
+		fmt.Fprintf(w, "</h%d>\n", b.level)
+	case codeBlock:
+		w.WriteString("<pre><code")
+		if b.info != "" {
+			fmt.Fprintf(w, " class=\"language-%s\"", template.HTMLEscapeString(b.info))
+		}
+		w.WriteString(">")
+		if r.Code != nil {
+			w.WriteString(string(r.Code(b.info, b.text)))
+		} else {
+			template.HTMLEscape(w, []byte(b.text))
+		}
+		w.WriteString("</code></pre>\n")
+	case thematicBreak:
+		w.WriteString("<hr>\n")
+	case blockQuote:
+		w.WriteString("<blockquote>\n")
+		r.renderBlocks(b.children, false)
+		w.WriteString("</blockquote>\n")
+	case list:
+		tag := "ul"
+		if b.ordered {
+			tag = "ol"
+		}
+		w.WriteString("<" + tag)
+		if b.ordered && b.start != 1 {
+			fmt.Fprintf(w, " start=\"%d\"", b.start)
+		}
+		w.WriteString(">\n")
+		for _, item := range b.children {
+			w.WriteString("<li>")
+			r.renderBlocks(item.children, b.tight)
+			w.WriteString("</li>\n")
+		}
+		w.WriteString("</" + tag + ">\n")
+	case table:
+		w.WriteString("<table>\n")
+		for i, row := range b.rows {
+			cell := "td"
+			if i == 0 {
+				cell = "th"
+				w.WriteString("<thead>\n")
+			} else if i == 1 {
+				w.WriteString("<tbody>\n")
+			}
+			w.WriteString("<tr>")
+			for j := range b.align {
+				w.WriteString("<" + cell)
+				if b.align[j] != "" {
+					fmt.Fprintf(w, " style=\"text-align: %s\"", b.align[j])
+				}
+				w.WriteString(">")
+				if j < len(row) {
+					r.inline(row[j])
+				}
+				w.WriteString("</" + cell + ">")
+			}
+			w.WriteString("</tr>\n")
+			if i == 0 {
+				w.WriteString("</thead>\n")
+			}
+		}
+		if len(b.rows) > 1 {
+			w.WriteString("</tbody>\n")
+		}
+		w.WriteString("</table>\n")
+	}
+}
+
+// headingID returns a unique id for the heading with the given text, made
+// like GitHub's: lower case, spaces as hyphens, punctuation removed.
+func (r *renderer) headingID(text string) string {
+	var b strings.Builder
+	for _, c := range strings.ToLower(r.plainText(text)) {
+		switch {
+		case c == ' ':
+			b.WriteByte('-')
+		case c == '-' || c == '_' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c >= 0x80:
+			b.WriteRune(c)
+		}
+	}
+	id := b.String()
+	n := r.ids[id]
+	r.ids[id]++
+	if n > 0 {
+		id = fmt.Sprintf("%s-%d", id, n)
+	}
+	return id
+}
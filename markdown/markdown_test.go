@@ -0,0 +1,69 @@
+package markdown
+
+import (
+	"html/template"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name, src, want string
+	}{
+		{"empty", "", ""},
+		{"inline", "# Title\n\nSome *emphasis*, **strong**, ~~gone~~ and `code`.",
+			"<h1 id=\"title\">Title</h1>\n<p>Some <em>emphasis</em>, <strong>strong</strong>, <del>gone</del> and <code>code</code>.</p>\n"},
+		{"setext heading", "Setext\n===\n\nline one\nline two",
+			"<h1 id=\"setext\">Setext</h1>\n<p>line one\nline two</p>\n"},
+		{"heading ids", "## Same\n## Same\n### Hello, World!",
+			"<h2 id=\"same\">Same</h2>\n<h2 id=\"same-1\">Same</h2>\n<h3 id=\"hello-world\">Hello, World!</h3>\n"},
+		{"fenced code", "```go\nfmt.Println(\"<hi>\")\n```",
+			"<pre><code class=\"language-go\">fmt.Println(&#34;&lt;hi&gt;&#34;)\n</code></pre>\n"},
+		{"indented code", "    indented\n    code", "<pre><code>indented\ncode\n</code></pre>\n"},
+		{"tab-indented code", "tab\n\n\tcode", "<p>tab</p>\n<pre><code>code\n</code></pre>\n"},
+		{"block quotes", "> quoted\n> > nested",
+			"<blockquote>\n<p>quoted</p>\n<blockquote>\n<p>nested</p>\n</blockquote>\n</blockquote>\n"},
+		{"nested lists", "- a\n- b\n  - c\n\n1. x\n2. y",
+			"<ul>\n<li>a\n</li>\n<li>b\n<ul>\n<li>c\n</li>\n</ul>\n</li>\n</ul>\n<ol>\n<li>x\n</li>\n<li>y\n</li>\n</ol>\n"},
+		{"ordered list start", "3. three\n4. four", "<ol start=\"3\">\n<li>three\n</li>\n<li>four\n</li>\n</ol>\n"},
+		{"loose list", "- loose\n\n- items", "<ul>\n<li><p>loose</p>\n</li>\n<li><p>items</p>\n</li>\n</ul>\n"},
+		{"thematic breaks", "---\n***", "<hr>\n<hr>\n"},
+		{"links", "[go](https://go.dev \"Go\") [ref][1] <https://example.com> https://bare.example/x.\n\n[1]: /rel/path",
+			"<p><a href=\"https://go.dev\" title=\"Go\">go</a> <a href=\"/rel/path\">ref</a> <a href=\"https://example.com\">https://example.com</a> <a href=\"https://bare.example/x\">https://bare.example/x</a>.</p>\n"},
+		{"image and unsafe link", "![alt](img.png) [x](javascript:alert(1))",
+			"<p><img src=\"img.png\" alt=\"alt\"> <a href=\"#\">x</a></p>\n"},
+		{"raw HTML and escapes", "<script>alert(1)</script> & \\*not em\\*",
+			"<p>&lt;script&gt;alert(1)&lt;/script&gt; &amp; *not em*</p>\n"},
+		{"code spans", "a ``b ` c`` d\n`unclosed", "<p>a <code>b ` c</code> d\n`unclosed</p>\n"},
+		{"table", "| a | b |\n|:--|--:|\n| 1 | 2 |",
+			"<table>\n<thead>\n<tr><th style=\"text-align: left\">a</th><th style=\"text-align: right\">b</th></tr>\n</thead>\n<tbody>\n<tr><td style=\"text-align: left\">1</td><td style=\"text-align: right\">2</td></tr>\n</tbody>\n</table>\n"},
+	}
+	var r Renderer
+	for _, tt := range tests {
+		if got := string(r.Render([]byte(tt.src))); got != tt.want {
+			t.Errorf("%s: Render(%q) =\n%q\nwant\n%q", tt.name, tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestRenderHooks(t *testing.T) {
+	r := Renderer{
+		Link:  func(dest string) string { return "/show/" + dest },
+		Image: func(src string) string { return "/show/" + src + "?raw=1" },
+		Code: func(info, code string) template.HTML {
+			return template.HTML("<span class=\"" + info + "\">" + template.HTMLEscapeString(code) + "</span>")
+		},
+	}
+	tests := []struct {
+		name, src, want string
+	}{
+		{"link", "[doc](doc.md)", "<p><a href=\"/show/doc.md\">doc</a></p>\n"},
+		{"reference link", "[doc][d]\n\n[d]: doc.md", "<p><a href=\"/show/doc.md\">doc</a></p>\n"},
+		{"image", "![logo](logo.png)", "<p><img src=\"/show/logo.png?raw=1\" alt=\"logo\"></p>\n"},
+		{"code", "```go\nx < y\n```", "<pre><code class=\"language-go\"><span class=\"go\">x &lt; y\n</span></code></pre>\n"},
+	}
+	for _, tt := range tests {
+		if got := string(r.Render([]byte(tt.src))); got != tt.want {
+			t.Errorf("%s: Render(%q) =\n%q\nwant\n%q", tt.name, tt.src, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+// Package memos reads the memos of a Memos (https://usememos.com) database.
+package memos
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/touchmarine/sandd/sqlite"
+)
+
+// Memo is a memo.
+type Memo struct {
+	ID      int64
+	UID     string // identifies the memo in URLs
+	Content string // Markdown
+	Updated time.Time
+}
+
+// Load returns the memos in the SQLite database file, except archived
+// ones, in the order they were created.
+func Load(file string) ([]Memo, error) {
+	db, err := sqlite.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	t, err := db.Table("memo")
+	if err != nil {
+		return nil, err
+	}
+	var memos []Memo
+	err = t.Rows(func(r sqlite.Row) error {
+		if s, ok := r["row_status"].(string); ok && s != "NORMAL" {
+			return nil
+		}
+		m := Memo{}
+		m.ID, _ = r["id"].(int64)
+		m.Content, _ = r["content"].(string)
+		// before Memos 0.22, the uid was the resource_name
+		if m.UID, _ = r["uid"].(string); m.UID == "" {
+			m.UID, _ = r["resource_name"].(string)
+		}
+		if m.UID == "" {
+			m.UID = strconv.FormatInt(m.ID, 10)
+		}
+		if ts, ok := r["updated_ts"].(int64); ok {
+			m.Updated = time.Unix(ts, 0)
+		}
+		memos = append(memos, m)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return memos, nil
+}
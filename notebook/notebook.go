@@ -0,0 +1,194 @@
+// Package notebook reads Jupyter notebooks (.ipynb files).
+package notebook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Notebook is a Jupyter notebook (nbformat 4).
+type Notebook struct {
+	Cells    []Cell
+	Language string // kernel language, e.g. "python"
+}
+
+// Cell is a notebook cell.
+type Cell struct {
+	Type           string // "code", "markdown" or "raw"
+	Source         string
+	ExecutionCount int // 0 if the cell has not been executed
+	Outputs        []Output
+}
+
+// Output is an output of a code cell.
+type Output struct {
+	Type string // "stream", "execute_result", "display_data" or "error"
+	Name string // stream name, "stdout" or "stderr"
+	// Data maps MIME types to the output data: text for text types,
+	// base64 for binary types. Stream and error outputs have "text/plain".
+	Data map[string]string
+}
+
+// Text returns the plain text of the output: its "text/plain" data with
+// terminal escape sequences removed.
+func (o Output) Text() string {
+	return ansiEscape.ReplaceAllString(o.Data["text/plain"], "")
+}
+
+var ansiEscape = regexp.MustCompile(`\x1b\[[0-9;]*[A-Za-z]`)
+
+// Lines returns the lines of the output's text, or nil if it has none.
+func (o Output) Lines() []string {
+	return splitLines(o.Text())
+}
+
+// splitLines splits s into lines. A final newline does not start another line.
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// IsNotebook reports whether the named file is a notebook.
+func IsNotebook(name string) bool {
+	return strings.HasSuffix(name, ".ipynb")
+}
+
+// multiline is a string stored either as a string or as a list of lines.
+type multiline string
+
+func (m *multiline) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*m = multiline(s)
+		return nil
+	}
+	var lines []string
+	if err := json.Unmarshal(data, &lines); err != nil {
+		return err
+	}
+	*m = multiline(strings.Join(lines, ""))
+	return nil
+}
+
+type jsonNotebook struct {
+	NBFormat int `json:"nbformat"`
+	Metadata struct {
+		KernelSpec struct {
+			Language string `json:"language"`
+		} `json:"kernelspec"`
+		LanguageInfo struct {
+			Name string `json:"name"`
+		} `json:"language_info"`
+	} `json:"metadata"`
+	Cells []struct {
+		CellType       string    `json:"cell_type"`
+		Source         multiline `json:"source"`
+		ExecutionCount *int      `json:"execution_count"`
+		Outputs        []struct {
+			OutputType string               `json:"output_type"`
+			Name       string               `json:"name"`
+			Text       multiline            `json:"text"`
+			Data       map[string]multiline `json:"data"`
+			EName      string               `json:"ename"`
+			EValue     string               `json:"evalue"`
+			Traceback  []string             `json:"traceback"`
+		} `json:"outputs"`
+	} `json:"cells"`
+}
+
+// Parse parses the notebook data.
+func Parse(data []byte) (*Notebook, error) {
+	var j jsonNotebook
+	if err := json.Unmarshal(data, &j); err != nil {
+		return nil, err
+	}
+	if j.NBFormat != 4 {
+		return nil, fmt.Errorf("unsupported nbformat %d", j.NBFormat)
+	}
+	nb := &Notebook{Language: j.Metadata.LanguageInfo.Name}
+	if nb.Language == "" {
+		nb.Language = j.Metadata.KernelSpec.Language
+	}
+	for _, jc := range j.Cells {
+		c := Cell{Type: jc.CellType, Source: string(jc.Source)}
+		if jc.ExecutionCount != nil {
+			c.ExecutionCount = *jc.ExecutionCount
+		}
+		for _, jo := range jc.Outputs {
+			o := Output{Type: jo.OutputType, Name: jo.Name, Data: make(map[string]string)}
+			switch jo.OutputType {
+			case "stream":
+				o.Data["text/plain"] = string(jo.Text)
+			case "error":
+				o.Data["text/plain"] = strings.Join(append([]string{jo.EName + ": " + jo.EValue}, jo.Traceback...), "\n")
+			default:
+				for typ, d := range jo.Data {
+					o.Data[typ] = string(d)
+				}
+			}
+			c.Outputs = append(c.Outputs, o)
+		}
+		nb.Cells = append(nb.Cells, c)
+	}
+	return nb, nil
+}
+
+// Line is the position of a line of the notebook's text.
+type Line struct {
+	Cell   int  // 1-based cell number
+	Line   int  // 1-based line number in the cell's source or outputs
+	Output bool // line of the cell's text outputs
+}
+
+func (l Line) String() string {
+	if l.Output {
+		return fmt.Sprintf("cell %d output, line %d", l.Cell, l.Line)
+	}
+	return fmt.Sprintf("cell %d, line %d", l.Cell, l.Line)
+}
+
+// Anchor returns the fragment identifying the line in a rendered notebook,
+// like "cell-3-L4" or "cell-3-out-L1".
+func (l Line) Anchor() string {
+	if l.Output {
+		return fmt.Sprintf("cell-%d-out-L%d", l.Cell, l.Line)
+	}
+	return fmt.Sprintf("cell-%d-L%d", l.Cell, l.Line)
+}
+
+// Text returns the text of the notebook for searching: the source of each
+// cell followed, if outputs is set, by the cell's text outputs. It also
+// returns the position of each line of the text.
+func (nb *Notebook) Text(outputs bool) ([]byte, []Line) {
+	var (
+		buf   bytes.Buffer
+		lines []Line
+	)
+	add := func(l Line, s string) {
+		buf.WriteString(s)
+		buf.WriteByte('\n')
+		lines = append(lines, l)
+	}
+	for i, c := range nb.Cells {
+		for j, s := range splitLines(c.Source) {
+			add(Line{Cell: i + 1, Line: j + 1}, s)
+		}
+		if !outputs {
+			continue
+		}
+		// the lines of all outputs are numbered together
+		n := 0
+		for _, o := range c.Outputs {
+			for _, s := range o.Lines() {
+				n++
+				add(Line{Cell: i + 1, Line: n, Output: true}, s)
+			}
+		}
+	}
+	return buf.Bytes(), lines
+}
@@ -0,0 +1,131 @@
+package sqlite
+
+import (
+	"errors"
+	"strings"
+)
+
+// parseCreateTable returns the column names of the table created by the
+// CREATE TABLE statement sql and the index of the column aliasing the
+// rowid, or -1 if there is none.
+func parseCreateTable(sql string) (cols []string, rowid int, err error) {
+	i := strings.IndexByte(sql, '(')
+	j := strings.LastIndexByte(sql, ')')
+	if i < 0 || j < i {
+		return nil, 0, errors.New("unsupported schema (CREATE TABLE ... AS SELECT?)")
+	}
+	if strings.Contains(strings.ToUpper(sql[j:]), "WITHOUT ROWID") {
+		return nil, 0, errors.New("WITHOUT ROWID tables are not supported")
+	}
+	rowid = -1
+	var (
+		types []string // upper-case column types
+		pk    string   // column of a table PRIMARY KEY constraint
+	)
+	for _, def := range splitDefs(sql[i+1 : j]) {
+		name, rest := ident(def)
+		if name == "" {
+			continue
+		}
+		upper := strings.ToUpper(strings.Join(strings.Fields(rest), " "))
+		switch strings.ToUpper(name) {
+		case "PRIMARY":
+			// table constraint PRIMARY KEY (col)
+			if k := strings.IndexByte(rest, '('); k >= 0 {
+				if c, after := ident(rest[k+1:]); strings.HasPrefix(strings.TrimSpace(after), ")") {
+					pk = c
+				}
+			}
+			continue
+		case "CONSTRAINT", "UNIQUE", "CHECK", "FOREIGN":
+			continue
+		}
+		// An INTEGER PRIMARY KEY column aliases the rowid and is stored
+		// as NULL.
+		if strings.HasPrefix(upper, "INTEGER PRIMARY KEY") && !strings.HasPrefix(upper, "INTEGER PRIMARY KEY DESC") {
+			rowid = len(cols)
+		}
+		cols = append(cols, name)
+		types = append(types, upper)
+	}
+	if pk != "" && rowid < 0 {
+		for k, c := range cols {
+			if strings.EqualFold(c, pk) && (types[k] == "INTEGER" || strings.HasPrefix(types[k], "INTEGER ")) {
+				rowid = k
+			}
+		}
+	}
+	if len(cols) == 0 {
+		return nil, 0, errors.New("no columns")
+	}
+	return cols, rowid, nil
+}
+
+// splitDefs splits the column definitions and table constraints s at the
+// commas outside parentheses and quotes.
+func splitDefs(s string) []string {
+	var (
+		defs  []string
+		depth int
+		quote byte
+		start int
+	)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '[':
+			quote = ']'
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			defs = append(defs, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(defs, s[start:])
+}
+
+// ident returns the identifier at the start of s, unquoted, and the rest
+// of s.
+func ident(s string) (name, rest string) {
+	s = strings.TrimLeft(s, " \t\r\n")
+	if s == "" {
+		return "", ""
+	}
+	var closing byte
+	switch s[0] {
+	case '"', '`', '\'':
+		closing = s[0]
+	case '[':
+		closing = ']'
+	default:
+		i := strings.IndexAny(s, " \t\r\n(")
+		if i < 0 {
+			return s, ""
+		}
+		return s[:i], s[i:]
+	}
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		if s[i] != closing {
+			b.WriteByte(s[i])
+			continue
+		}
+		if closing != ']' && i+1 < len(s) && s[i+1] == closing {
+			// doubled quote
+			b.WriteByte(closing)
+			i++
+			continue
+		}
+		return b.String(), s[i+1:]
+	}
+	return b.String(), ""
+}
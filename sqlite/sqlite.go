@@ -0,0 +1,471 @@
+// Package sqlite reads the tables of SQLite databases.
+//
+// It implements just enough of the file format
+// (https://www.sqlite.org/fileformat.html) to scan the rows of ordinary
+// tables without a driver. Databases are opened read-only and committed
+// transactions in the write-ahead log are included. Indexes, WITHOUT ROWID
+// tables and writing are not supported.
+package sqlite
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"unicode/utf16"
+)
+
+const headerSize = 100
+
+// DB is a database opened for reading.
+type DB struct {
+	f        *os.File
+	wal      *os.File         // write-ahead log, if any
+	walPages map[uint32]int64 // page number to offset of its data in wal
+	npages   uint32           // number of pages, in the file or wal
+	pageSize int
+	usable   int    // usable size of a page
+	encoding uint32 // text encoding: 1 UTF-8, 2 UTF-16le, 3 UTF-16be
+}
+
+// Open opens the database file for reading.
+func Open(name string) (*DB, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	db := &DB{f: f}
+	if err := db.readHeader(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	if err := db.openWAL(name + "-wal"); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s-wal: %v", name, err)
+	}
+	return db, nil
+}
+
+// Close closes the database.
+func (db *DB) Close() error {
+	if db.wal != nil {
+		db.wal.Close()
+	}
+	return db.f.Close()
+}
+
+func (db *DB) readHeader() error {
+	var h [headerSize]byte
+	if _, err := db.f.ReadAt(h[:], 0); err != nil {
+		if err == io.EOF {
+			return errors.New("not a database")
+		}
+		return err
+	}
+	if string(h[:16]) != "SQLite format 3\x00" {
+		return errors.New("not a database")
+	}
+	db.pageSize = int(binary.BigEndian.Uint16(h[16:]))
+	if db.pageSize == 1 {
+		db.pageSize = 65536
+	}
+	if db.pageSize < 512 || db.pageSize&(db.pageSize-1) != 0 {
+		return fmt.Errorf("bad page size %d", db.pageSize)
+	}
+	db.usable = db.pageSize - int(h[20])
+	if db.usable < 480 {
+		return fmt.Errorf("bad reserved space %d", h[20])
+	}
+	fi, err := db.f.Stat()
+	if err != nil {
+		return err
+	}
+	db.npages = uint32(min(fi.Size()/int64(db.pageSize), math.MaxUint32))
+	db.encoding = binary.BigEndian.Uint32(h[56:])
+	if db.encoding == 0 {
+		db.encoding = 1
+	}
+	return nil
+}
+
+// openWAL reads the index of the pages of the committed transactions in
+// the write-ahead log file, if it exists. Later frames of a page replace
+// earlier ones; frames after the last valid commit are ignored.
+func (db *DB) openWAL(name string) error {
+	f, err := os.Open(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var h [32]byte
+	if _, err := io.ReadFull(f, h[:]); err != nil {
+		// empty or incomplete log: nothing committed
+		f.Close()
+		return nil
+	}
+	magic := binary.BigEndian.Uint32(h[0:])
+	if magic&^1 != 0x377f0682 || int(binary.BigEndian.Uint32(h[8:])) != db.pageSize {
+		f.Close()
+		return nil
+	}
+	order := binary.ByteOrder(binary.LittleEndian)
+	if magic&1 != 0 {
+		order = binary.BigEndian
+	}
+	s0, s1 := walChecksum(order, 0, 0, h[:24])
+	if s0 != binary.BigEndian.Uint32(h[24:]) || s1 != binary.BigEndian.Uint32(h[28:]) {
+		f.Close()
+		return nil
+	}
+	salt := h[16:24]
+
+	pages := make(map[uint32]int64)
+	pending := make(map[uint32]int64) // pages of the uncommitted transaction
+	frame := make([]byte, 24+db.pageSize)
+	for off := int64(len(h)); ; off += int64(len(frame)) {
+		if _, err := f.ReadAt(frame, off); err != nil {
+			break
+		}
+		if !bytes.Equal(frame[8:16], salt) {
+			break
+		}
+		s0, s1 = walChecksum(order, s0, s1, frame[:8])
+		s0, s1 = walChecksum(order, s0, s1, frame[24:])
+		if s0 != binary.BigEndian.Uint32(frame[16:]) || s1 != binary.BigEndian.Uint32(frame[20:]) {
+			break
+		}
+		pending[binary.BigEndian.Uint32(frame[0:])] = off + 24
+		if binary.BigEndian.Uint32(frame[4:]) != 0 {
+			// commit
+			for p, o := range pending {
+				pages[p] = o
+				db.npages = max(db.npages, p)
+			}
+			clear(pending)
+		}
+	}
+	if len(pages) == 0 {
+		f.Close()
+		return nil
+	}
+	db.wal, db.walPages = f, pages
+	return nil
+}
+
+// walChecksum continues the checksum s0, s1 over b.
+func walChecksum(order binary.ByteOrder, s0, s1 uint32, b []byte) (uint32, uint32) {
+	for i := 0; i+8 <= len(b); i += 8 {
+		s0 += order.Uint32(b[i:]) + s1
+		s1 += order.Uint32(b[i+4:]) + s0
+	}
+	return s0, s1
+}
+
+// page returns the data of the page with the given 1-based number.
+func (db *DB) page(n uint32) ([]byte, error) {
+	if n == 0 {
+		return nil, errors.New("corrupt database: page 0")
+	}
+	p := make([]byte, db.pageSize)
+	var err error
+	if off, ok := db.walPages[n]; ok {
+		_, err = db.wal.ReadAt(p, off)
+	} else {
+		_, err = db.f.ReadAt(p, int64(n-1)*int64(db.pageSize))
+	}
+	if err != nil {
+		if err == io.EOF {
+			return nil, fmt.Errorf("corrupt database: page %d out of range", n)
+		}
+		return nil, err
+	}
+	return p, nil
+}
+
+// Row is a row of a table. It maps column names to values of type nil,
+// int64, float64, string or []byte.
+type Row map[string]any
+
+// Table is a table of the database.
+type Table struct {
+	Name    string
+	Columns []string
+
+	db    *DB
+	root  uint32
+	rowid int // index of the column aliasing the rowid, or -1
+}
+
+// Table returns the named table.
+func (db *DB) Table(name string) (*Table, error) {
+	schema := &Table{
+		Name:    "sqlite_schema",
+		Columns: []string{"type", "name", "tbl_name", "rootpage", "sql"},
+		db:      db,
+		root:    1,
+		rowid:   -1,
+	}
+	var t *Table
+	err := schema.Rows(func(r Row) error {
+		if n, _ := r["name"].(string); r["type"] != "table" || !strings.EqualFold(n, name) {
+			return nil
+		}
+		root, _ := r["rootpage"].(int64)
+		sql, _ := r["sql"].(string)
+		cols, rowid, err := parseCreateTable(sql)
+		if err != nil {
+			return fmt.Errorf("table %s: %v", name, err)
+		}
+		t = &Table{Name: name, Columns: cols, db: db, root: uint32(root), rowid: rowid}
+		return errStop
+	})
+	if err != nil && err != errStop {
+		return nil, err
+	}
+	if t == nil {
+		return nil, fmt.Errorf("no such table: %s", name)
+	}
+	return t, nil
+}
+
+var errStop = errors.New("stop")
+
+// Rows calls fn for each row of the table in rowid order. It stops and
+// returns the error if fn returns one.
+func (t *Table) Rows(fn func(Row) error) error {
+	return t.walk(t.root, fn, 0, make(map[uint32]bool))
+}
+
+// maxDepth bounds the depth of b-trees so that chains of pages in corrupt
+// databases do not recurse too deep.
+const maxDepth = 64
+
+// walk calls fn for each row of the b-tree page pgno at the given depth.
+// seen records the pages walked, which corrupt databases may link to
+// again.
+func (t *Table) walk(pgno uint32, fn func(Row) error, depth int, seen map[uint32]bool) error {
+	if depth > maxDepth {
+		return errors.New("corrupt database: b-tree too deep")
+	}
+	if seen[pgno] {
+		return fmt.Errorf("corrupt database: page %d linked twice", pgno)
+	}
+	seen[pgno] = true
+	p, err := t.db.page(pgno)
+	if err != nil {
+		return err
+	}
+	h := p
+	if pgno == 1 {
+		h = p[headerSize:]
+	}
+	ncells := int(binary.BigEndian.Uint16(h[3:]))
+	switch h[0] {
+	case 0x05: // interior table page
+		ptrs := h[12:]
+		if len(ptrs) < 2*ncells {
+			return errors.New("corrupt database: bad cell count")
+		}
+		for i := 0; i < ncells; i++ {
+			off := int(binary.BigEndian.Uint16(ptrs[2*i:]))
+			if off+4 > len(p) {
+				return errors.New("corrupt database: bad cell offset")
+			}
+			if err := t.walk(binary.BigEndian.Uint32(p[off:]), fn, depth+1, seen); err != nil {
+				return err
+			}
+		}
+		return t.walk(binary.BigEndian.Uint32(h[8:]), fn, depth+1, seen)
+	case 0x0d: // leaf table page
+		ptrs := h[8:]
+		if len(ptrs) < 2*ncells {
+			return errors.New("corrupt database: bad cell count")
+		}
+		for i := 0; i < ncells; i++ {
+			off := int(binary.BigEndian.Uint16(ptrs[2*i:]))
+			if off >= len(p) {
+				return errors.New("corrupt database: bad cell offset")
+			}
+			r, err := t.cell(p[off:])
+			if err != nil {
+				return err
+			}
+			if err := fn(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("corrupt database: page %d is not a table page", pgno)
+}
+
+// cell decodes the leaf table cell c.
+func (t *Table) cell(c []byte) (Row, error) {
+	size, n := varint(c)
+	if n == 0 {
+		return nil, errors.New("corrupt database: bad cell")
+	}
+	c = c[n:]
+	rowid, n := varint(c)
+	if n == 0 {
+		return nil, errors.New("corrupt database: bad cell")
+	}
+	c = c[n:]
+	// The payload is in the database, on overflow pages if need be.
+	if size > uint64(t.db.npages)*uint64(t.db.usable) {
+		return nil, errors.New("corrupt database: bad cell size")
+	}
+	payload, err := t.db.payload(c, int(size))
+	if err != nil {
+		return nil, err
+	}
+	vals, err := t.db.record(payload)
+	if err != nil {
+		return nil, err
+	}
+	r := make(Row, len(t.Columns))
+	for i, col := range t.Columns {
+		switch {
+		case i == t.rowid:
+			r[col] = int64(rowid)
+		case i < len(vals):
+			r[col] = vals[i]
+		default:
+			// column added later: NULL unless it has a default, which
+			// is not supported
+			r[col] = nil
+		}
+	}
+	return r, nil
+}
+
+// payload returns the payload of the given size starting at c, following
+// its overflow pages.
+func (db *DB) payload(c []byte, size int) ([]byte, error) {
+	u := db.usable
+	x := u - 35
+	local := size
+	if size > x {
+		m := (u-12)*32/255 - 23
+		local = m + (size-m)%(u-4)
+		if local > x {
+			local = m
+		}
+	}
+	if local > len(c) || local < size && local+4 > len(c) {
+		return nil, errors.New("corrupt database: bad cell size")
+	}
+	buf := make([]byte, 0, size)
+	buf = append(buf, c[:local]...)
+	if local == size {
+		return buf, nil
+	}
+	next := binary.BigEndian.Uint32(c[local:])
+	for len(buf) < size {
+		if next == 0 {
+			return nil, errors.New("corrupt database: short overflow chain")
+		}
+		p, err := db.page(next)
+		if err != nil {
+			return nil, err
+		}
+		next = binary.BigEndian.Uint32(p)
+		buf = append(buf, p[4:min(u, 4+size-len(buf))]...)
+	}
+	return buf, nil
+}
+
+// record decodes the values of the record b.
+func (db *DB) record(b []byte) ([]any, error) {
+	hsize, n := varint(b)
+	if n == 0 || hsize < uint64(n) || hsize > uint64(len(b)) {
+		return nil, errors.New("corrupt database: bad record header")
+	}
+	h, body := b[n:hsize], b[hsize:]
+	var vals []any
+	for len(h) > 0 {
+		typ, n := varint(h)
+		if n == 0 {
+			return nil, errors.New("corrupt database: bad record header")
+		}
+		h = h[n:]
+		var size uint64
+		switch {
+		case typ <= 4:
+			size = typ
+		case typ == 5:
+			size = 6
+		case typ == 6 || typ == 7:
+			size = 8
+		case typ >= 12:
+			size = (typ - 12) / 2
+		}
+		if size > uint64(len(body)) {
+			return nil, errors.New("corrupt database: bad record")
+		}
+		v, data := body[:size], body[size:]
+		body = data
+		switch {
+		case typ == 0:
+			vals = append(vals, nil)
+		case typ <= 6:
+			// big-endian two's complement
+			x := int64(int8(v[0]))
+			for _, c := range v[1:] {
+				x = x<<8 | int64(c)
+			}
+			vals = append(vals, x)
+		case typ == 7:
+			vals = append(vals, math.Float64frombits(binary.BigEndian.Uint64(v)))
+		case typ == 8:
+			vals = append(vals, int64(0))
+		case typ == 9:
+			vals = append(vals, int64(1))
+		case typ >= 12 && typ%2 == 0:
+			vals = append(vals, bytes.Clone(v))
+		case typ >= 13:
+			vals = append(vals, db.text(v))
+		default:
+			return nil, fmt.Errorf("corrupt database: bad serial type %d", typ)
+		}
+	}
+	return vals, nil
+}
+
+// text decodes the text b in the database encoding.
+func (db *DB) text(b []byte) string {
+	if db.encoding == 1 {
+		return string(b)
+	}
+	order := binary.ByteOrder(binary.LittleEndian)
+	if db.encoding == 3 {
+		order = binary.BigEndian
+	}
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = order.Uint16(b[2*i:])
+	}
+	return string(utf16.Decode(u))
+}
+
+// varint decodes the variable-length integer at the start of b and returns
+// it and its length, or 0 if b is too short.
+func varint(b []byte) (uint64, int) {
+	var x uint64
+	for i := 0; i < 9 && i < len(b); i++ {
+		if i == 8 {
+			return x<<8 | uint64(b[i]), 9
+		}
+		x = x<<7 | uint64(b[i]&0x7f)
+		if b[i] < 0x80 {
+			return x, i + 1
+		}
+	}
+	return 0, 0
+}
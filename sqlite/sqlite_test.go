@@ -0,0 +1,167 @@
+package sqlite
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// The databases in testdata are made with the sqlite3 command: types.db,
+// with 512-byte pages, has values of all types, a text on overflow pages,
+// interior pages and a column added by ALTER TABLE; utf16.db is in UTF-16;
+// wal.db has transactions in its write-ahead log, not checkpointed.
+
+// typesRows returns the rows of the table t of types.db.
+func typesRows() []Row {
+	rows := []Row{
+		{"id": int64(1), "i": int64(0), "r": 1.5, "s": "hello", "b": []byte{0, 0xff}, "added": nil},
+		{"id": int64(2), "i": int64(-1), "r": -2.25, "s": "café", "b": []byte{}, "added": nil},
+		// integral REAL values are stored as integers
+		{"id": int64(3), "i": int64(1<<63 - 1), "r": int64(0), "s": strings.Repeat("x", 3000), "b": nil, "added": nil},
+	}
+	for id := int64(4); id <= 60; id++ {
+		rows = append(rows, Row{"id": id, "i": id * id, "r": nil, "s": fmt.Sprintf("row %d", id), "b": nil, "added": nil})
+	}
+	return append(rows, Row{"id": int64(61), "i": int64(1), "r": nil, "s": nil, "b": nil, "added": "new"})
+}
+
+func TestRows(t *testing.T) {
+	tests := []struct {
+		file string
+		cols []string
+		want []Row
+	}{
+		{"types.db", []string{"id", "i", "r", "s", "b", "added"}, typesRows()},
+		{"utf16.db", []string{"the id", "s"}, []Row{{"the id": int64(7), "s": "café ☕"}}},
+		{"wal.db", []string{"id", "s"}, []Row{
+			{"id": int64(1), "s": "updated in the log"},
+			{"id": int64(2), "s": "in the log"},
+		}},
+	}
+	for _, tt := range tests {
+		db, err := Open(filepath.Join("testdata", tt.file))
+		if err != nil {
+			t.Errorf("%s: %v", tt.file, err)
+			continue
+		}
+		defer db.Close()
+		tab, err := db.Table("T")
+		if err != nil {
+			t.Errorf("%s: %v", tt.file, err)
+			continue
+		}
+		if !reflect.DeepEqual(tab.Columns, tt.cols) {
+			t.Errorf("%s: columns %q, want %q", tt.file, tab.Columns, tt.cols)
+		}
+		var rows []Row
+		if err := tab.Rows(func(r Row) error {
+			rows = append(rows, r)
+			return nil
+		}); err != nil {
+			t.Errorf("%s: %v", tt.file, err)
+		}
+		if len(rows) != len(tt.want) {
+			t.Errorf("%s: %d rows, want %d", tt.file, len(rows), len(tt.want))
+			continue
+		}
+		for i, r := range rows {
+			if !reflect.DeepEqual(r, tt.want[i]) {
+				t.Errorf("%s: row %d = %.200v, want %.200v", tt.file, i+1, r, tt.want[i])
+			}
+		}
+		if _, err := db.Table("missing"); err == nil {
+			t.Errorf("%s: no error for a missing table", tt.file)
+		}
+	}
+}
+
+func TestRecord(t *testing.T) {
+	db := &DB{encoding: 1}
+	tests := []struct {
+		name   string
+		record string
+		want   []any // nil if the record is corrupt
+	}{
+		{"values", "\x07\x00\x01\x07\x0f\x0e\x08\x2a\x3f\xf8\x00\x00\x00\x00\x00\x00a\xff", []any{nil, int64(42), 1.5, "a", []byte{0xff}, int64(0)}},
+		{"empty", "\x01", []any{}},
+		{"empty data", "", nil},
+		{"header size below its length", "\x00", nil},
+		{"header size past the end", "\x09\x01", nil},
+		{"header size cut", "\x81", nil},
+		{"serial type cut", "\x02\x81", nil},
+		{"value past the end", "\x02\x06\x00", nil},
+		{"text past the end", "\x02\x21abc", nil},
+		{"huge serial type", "\x0a\xff\xff\xff\xff\xff\xff\xff\xff\xfex", nil},
+		{"reserved serial type", "\x02\x0a", nil},
+	}
+	for _, tt := range tests {
+		vals, err := db.record([]byte(tt.record))
+		switch {
+		case tt.want == nil && err == nil:
+			t.Errorf("%s: record = %v, want error", tt.name, vals)
+		case tt.want != nil && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case tt.want != nil && len(vals)+len(tt.want) > 0 && !reflect.DeepEqual(vals, tt.want):
+			t.Errorf("%s: record = %v, want %v", tt.name, vals, tt.want)
+		}
+	}
+}
+
+// TestCorrupt reads the test databases with each byte overwritten, which
+// must return an error or rows, not panic or hang.
+func TestCorrupt(t *testing.T) {
+	if testing.Short() {
+		t.Skip("reads the databases thousands of times")
+	}
+	dir := t.TempDir()
+	for _, file := range []string{"types.db", "wal.db", "wal.db-wal"} {
+		db := strings.TrimSuffix(file, "-wal")
+		for _, f := range []string{db, db + "-wal"} {
+			data, err := os.ReadFile(filepath.Join("testdata", f))
+			if err != nil && !os.IsNotExist(err) {
+				t.Fatal(err)
+			}
+			os.Remove(filepath.Join(dir, f))
+			if err == nil {
+				if err := os.WriteFile(filepath.Join(dir, f), data, 0o666); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		f, err := os.OpenFile(filepath.Join(dir, file), os.O_RDWR, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fi, err := f.Stat()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for off := range fi.Size() {
+			var old [1]byte
+			f.ReadAt(old[:], off)
+			for _, b := range []byte{0x00, 0x7f, 0x80, 0xff} {
+				f.WriteAt([]byte{b}, off)
+				readAll(filepath.Join(dir, db))
+			}
+			f.WriteAt(old[:], off)
+		}
+		f.Close()
+	}
+}
+
+// readAll reads the rows of the table t of the database file, if it can.
+func readAll(file string) {
+	db, err := Open(file)
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	t, err := db.Table("t")
+	if err != nil {
+		return
+	}
+	t.Rows(func(Row) error { return nil })
+}